@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	accessLogContextKey = contextKey("access_log")
+	redactedValue       = "REDACTED"
+
+	RateLimitOutcomeAllowed = "allowed"
+	RateLimitOutcomeLimited = "limited"
+)
+
+// Параметры запроса, значения которых не должны попасть в лог
+var sensitiveParams = []string{"password", "token", "secret", "code", "csrf", "key"}
+
+// Одна строка журнала запросов
+type AccessLogEntry struct {
+	Time               time.Time `json:"time"`
+	RequestID          string    `json:"request_id"`
+	Method             string    `json:"method"`
+	Path               string    `json:"path"`
+	Query              string    `json:"query,omitempty"`
+	Status             int       `json:"status"`
+	Bytes              int64     `json:"bytes"`
+	LatencyMS          float64   `json:"latency_ms"`
+	ClientIP           string    `json:"client_ip"`
+	UserAgent          string    `json:"user_agent,omitempty"`
+	Username           string    `json:"username,omitempty"`
+	RateLimit          string    `json:"rate_limit,omitempty"` // allowed или limited, пусто — маршрут без лимита
+	RateLimitRemaining *int      `json:"rate_limit_remaining,omitempty"`
+}
+
+// Пишет записи в фоне через буфер фиксированного размера. Если буфер
+// полон, запись отбрасывается: журнал не должен тормозить запросы
+type AccessLog struct {
+	queue *asyncQueue[AccessLogEntry]
+
+	Logger *slog.Logger
+}
+
+func NewAccessLog(writer io.Writer, buffer int) *AccessLog {
+	accessLog := &AccessLog{}
+
+	encoder := json.NewEncoder(writer)
+	accessLog.queue = newAsyncQueue(buffer, func(entry AccessLogEntry) {
+		if err := encoder.Encode(entry); err != nil {
+			loggerOrDiscard(accessLog.Logger).Error("access log write error", "component", "access_log", "error", err)
+		}
+	})
+	return accessLog
+}
+
+func (a *AccessLog) record(entry AccessLogEntry) {
+	a.queue.push(entry)
+}
+
+// Сколько записей отброшено из-за переполнения буфера
+func (a *AccessLog) Dropped() uint64 {
+	return a.queue.Dropped()
+}
+
+// Дописывает оставшиеся в буфере записи. Записи после Close
+// отбрасываются
+func (a *AccessLog) Close() error {
+	if a.queue.close() {
+		if dropped := a.Dropped(); dropped > 0 {
+			loggerOrDiscard(a.Logger).Warn("access log entries dropped", "component", "access_log", "count", dropped)
+		}
+	}
+	return nil
+}
+
+// Запоминает статус и размер ответа
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Обработчики ниже по цепочке дополняют запись через annotateAccessLog:
+// имя пользователя и решение лимитера известны только им
+func AccessLogMiddleware(accessLog *AccessLog, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &AccessLogEntry{
+			Time:      start.UTC(),
+			RequestID: requestIDFromContext(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     redactQuery(r.URL.Query()),
+			ClientIP:  getClientIP(r),
+			UserAgent: r.UserAgent(),
+		}
+
+		recorder := &accessLogResponseWriter{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), accessLogContextKey, entry)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		entry.Status = recorder.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Bytes = recorder.bytes
+		entry.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+		accessLog.record(*entry)
+	})
+}
+
+func annotateAccessLog(ctx context.Context, annotate func(*AccessLogEntry)) {
+	if entry, ok := ctx.Value(accessLogContextKey).(*AccessLogEntry); ok {
+		annotate(entry)
+	}
+}
+
+func recordAccessLogUser(ctx context.Context, username string) {
+	annotateAccessLog(ctx, func(entry *AccessLogEntry) {
+		entry.Username = username
+	})
+}
+
+func recordAccessLogRateLimit(ctx context.Context, decision RateLimitDecision) {
+	annotateAccessLog(ctx, func(entry *AccessLogEntry) {
+		entry.RateLimit = RateLimitOutcomeAllowed
+		if !decision.Allowed {
+			entry.RateLimit = RateLimitOutcomeLimited
+		}
+
+		if decision.Limit > 0 {
+			remaining := decision.Remaining
+			entry.RateLimitRemaining = &remaining
+		}
+	})
+}
+
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveParams {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactQuery(query url.Values) string {
+	for name, values := range query {
+		if isSensitiveParam(name) {
+			for i := range values {
+				values[i] = redactedValue
+			}
+		}
+	}
+	return query.Encode()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Журнал в файле во временном каталоге; записи читаются после Close
+func newTestAccessLog(t *testing.T, buffer int) (*AccessLog, func() []AccessLogEntry) {
+	saver := NewSaver(filepath.Join(t.TempDir(), "requests.jsonl"), SaverOptions{})
+	require.NoError(t, saver.Start())
+
+	accessLog := NewAccessLog(saver, buffer)
+
+	read := func() []AccessLogEntry {
+		require.NoError(t, accessLog.Close())
+		require.NoError(t, saver.Stop())
+
+		data, err := os.ReadFile(saver.path)
+		require.NoError(t, err)
+
+		var entries []AccessLogEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry AccessLogEntry
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	return accessLog, read
+}
+
+func TestAccessLogMiddleware_RecordsRequest(t *testing.T) {
+	accessLog, read := newTestAccessLog(t, 16)
+
+	handler := RequestIDMiddleware(AccessLogMiddleware(accessLog, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/register?next=%2Fme&access_token=abc&password=hunter2", strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set(requestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "test-agent")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := read()
+	require.Len(t, entries, 1)
+	entry := entries[0]
+
+	assert.Equal(t, "req-1", entry.RequestID)
+	assert.Equal(t, http.MethodPost, entry.Method)
+	assert.Equal(t, "/register", entry.Path)
+	assert.Equal(t, "access_token=REDACTED&next=%2Fme&password=REDACTED", entry.Query)
+	assert.Equal(t, http.StatusCreated, entry.Status)
+	assert.Equal(t, int64(5), entry.Bytes)
+	assert.Equal(t, "192.0.2.1", entry.ClientIP)
+	assert.Equal(t, "test-agent", entry.UserAgent)
+	assert.WithinDuration(t, time.Now(), entry.Time, time.Minute)
+	assert.GreaterOrEqual(t, entry.LatencyMS, 0.0)
+	assert.Empty(t, entry.RateLimit)
+}
+
+func TestAccessLogMiddleware_UserAndRateLimit(t *testing.T) {
+	accessLog, read := newTestAccessLog(t, 16)
+
+	jwtKey := "test-secret-key"
+	limiter := NewRateLimiter(1, time.Minute)
+	handler := AccessLogMiddleware(accessLog, RateLimitMiddleware(limiter,
+		middelwareHandler(secretHandler, NewHMACKeyManager([]byte(jwtKey)), nil)))
+
+	token := generateValidToken(jwtKey, "alice")
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := read()
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, http.StatusOK, entries[0].Status)
+	assert.Equal(t, "alice", entries[0].Username)
+	assert.Equal(t, RateLimitOutcomeAllowed, entries[0].RateLimit)
+	require.NotNil(t, entries[0].RateLimitRemaining)
+	assert.Equal(t, 0, *entries[0].RateLimitRemaining)
+
+	// До проверки токена дело не дошло, пользователь неизвестен
+	assert.Equal(t, http.StatusTooManyRequests, entries[1].Status)
+	assert.Empty(t, entries[1].Username)
+	assert.Equal(t, RateLimitOutcomeLimited, entries[1].RateLimit)
+}
+
+// Не пропускает запись, пока не открыт gate
+type blockingWriter struct {
+	gate chan struct{}
+	buf  bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	return w.buf.Write(p)
+}
+
+func TestAccessLog_DropsWhenBufferFull(t *testing.T) {
+	writer := &blockingWriter{gate: make(chan struct{})}
+	accessLog := NewAccessLog(writer, 2)
+
+	handler := AccessLogMiddleware(accessLog, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}()
+
+	// Запросы не ждут записи в журнал
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("requests blocked on a full access log")
+	}
+
+	close(writer.gate)
+	require.NoError(t, accessLog.Close())
+
+	written := strings.Count(writer.buf.String(), "\n")
+	assert.Equal(t, uint64(10-written), accessLog.Dropped())
+	assert.LessOrEqual(t, written, 3)
+}
+
+// Обработчик, переживший остановку сервера, не должен уронить процесс
+func TestAccessLog_RecordAfterClose(t *testing.T) {
+	accessLog := NewAccessLog(io.Discard, 2)
+	require.NoError(t, accessLog.Close())
+
+	handler := AccessLogMiddleware(accessLog, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.NotPanics(t, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.Equal(t, uint64(1), accessLog.Dropped())
+}
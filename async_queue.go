@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Очередь фиксированного размера, которую разбирает одна горутина.
+// Если очередь полна, элемент отбрасывается: писатель не должен ждать.
+// Элементы после close тоже отбрасываются — их может прислать
+// обработчик, переживший остановку сервера
+type asyncQueue[T any] struct {
+	items   chan T
+	sink    func(T)
+	dropped atomic.Uint64
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncQueue[T any](buffer int, sink func(T)) *asyncQueue[T] {
+	queue := &asyncQueue[T]{
+		items: make(chan T, buffer),
+		sink:  sink,
+		done:  make(chan struct{}),
+	}
+
+	go queue.run()
+	return queue
+}
+
+func (q *asyncQueue[T]) run() {
+	defer close(q.done)
+
+	for item := range q.items {
+		q.sink(item)
+	}
+}
+
+func (q *asyncQueue[T]) push(item T) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.dropped.Add(1)
+		return
+	}
+
+	select {
+	case q.items <- item:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+func (q *asyncQueue[T]) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+// Дожидается обработки оставшихся элементов. false — очередь уже
+// была закрыта
+func (q *asyncQueue[T]) close() bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	q.closed = true
+	close(q.items)
+	q.mu.Unlock()
+
+	<-q.done
+	return true
+}
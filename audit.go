@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	auditContextKey = contextKey("audit")
+
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+type AuditEventType string
+
+const (
+	AuditRegister            AuditEventType = "register"
+	AuditLoginSuccess        AuditEventType = "login_success"
+	AuditLoginFailure        AuditEventType = "login_failure"
+	AuditTokenRejected       AuditEventType = "token_rejected"
+	AuditRateLimited         AuditEventType = "rate_limited"
+	AuditAccountLocked       AuditEventType = "account_locked"
+	AuditAccountUnlocked     AuditEventType = "account_unlocked"
+	AuditMFAEnabled          AuditEventType = "mfa_enabled"
+	AuditMFARecoveryCodeUsed AuditEventType = "mfa_recovery_code_used"
+	AuditRefreshTokenReuse   AuditEventType = "refresh_token_reuse"
+	AuditRoleGranted         AuditEventType = "role_granted"
+)
+
+// Событие безопасности. PrevHash и Hash связывают события в цепочку:
+// изменение или удаление любой записи ломает хэши всех следующих
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	Time      time.Time         `json:"time"`
+	Type      AuditEventType    `json:"type"`
+	Username  string            `json:"username,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+type AuditFilter struct {
+	Username string
+	IP       string
+	Type     AuditEventType
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+type IAuditLog interface {
+	Record(ctx context.Context, event AuditEvent) (AuditEvent, error)
+	Query(ctx context.Context, filter AuditFilter) ([]AuditEvent, error)
+}
+
+func WithAuditLog(ctx context.Context, audit IAuditLog) context.Context {
+	return context.WithValue(ctx, auditContextKey, audit)
+}
+
+// Сохраняет событие в журнал аудита из контекста и дублирует его
+// в общий лог. IP и ID запроса берутся из контекста, если не заданы.
+// Ошибка записи только логируется: аудит не должен ломать запрос
+func recordAudit(ctx context.Context, event AuditEvent) {
+	event = logAuditEvent(ctx, event)
+
+	audit, ok := ctx.Value(auditContextKey).(IAuditLog)
+	if !ok {
+		return
+	}
+
+	if _, err := audit.Record(ctx, event); err != nil {
+		loggerFromContext(ctx).Error("audit event saving error", "component", "audit", "event", event.Type, "error", err)
+	}
+}
+
+// Как recordAudit, но событие сохраняется в фоне через AuditQueue
+// из контекста, а без очереди — сразу
+func recordAuditAsync(ctx context.Context, event AuditEvent) {
+	queue, ok := ctx.Value(auditQueueContextKey).(*AuditQueue)
+	if !ok || queue == nil {
+		recordAudit(ctx, event)
+		return
+	}
+
+	queue.record(logAuditEvent(ctx, event))
+}
+
+func logAuditEvent(ctx context.Context, event AuditEvent) AuditEvent {
+	if event.IP == "" {
+		event.IP, _ = ctx.Value(clientIPContextKey).(string)
+	}
+	if event.RequestID == "" {
+		event.RequestID = requestIDFromContext(ctx)
+	}
+
+	attrs := []any{"event", event.Type, "username", event.Username}
+	for key, value := range event.Details {
+		attrs = append(attrs, key, value)
+	}
+	loggerFromContext(ctx).Info("audit", attrs...)
+
+	return event
+}
+
+// Кладёт журнал аудита и очередь к нему в контекст каждого запроса.
+// Без очереди все события пишутся синхронно
+func AuditMiddleware(audit IAuditLog, queue *AuditQueue, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithAuditLog(r.Context(), audit)
+		if queue != nil {
+			ctx = WithAuditQueue(ctx, queue)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type AuditQueryResponse struct {
+	Events []AuditEvent `json:"events"`
+}
+
+type AuditHandler struct {
+	Audit IAuditLog
+}
+
+// GET /admin/audit?username=&ip=&type=&since=&until=&limit=
+// since и until в RFC 3339, события отдаются от новых к старым
+func (h *AuditHandler) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	filter, fieldErrors := parseAuditFilter(r)
+	if len(fieldErrors) > 0 {
+		writeAPIError(w, r, NewAPIError(http.StatusBadRequest, ErrCodeInvalidInput, "Invalid audit query").WithFields(fieldErrors...))
+		return
+	}
+
+	events, err := h.Audit.Query(r.Context(), filter)
+	if err != nil {
+		requestLogger(r, "audit").Error("audit query error", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+		return
+	}
+
+	if events == nil {
+		events = []AuditEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(AuditQueryResponse{Events: events})
+}
+
+func parseAuditFilter(r *http.Request) (AuditFilter, []FieldError) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Username: query.Get("username"),
+		IP:       query.Get("ip"),
+		Type:     AuditEventType(query.Get("type")),
+		Limit:    defaultAuditQueryLimit,
+	}
+
+	var fieldErrors []FieldError
+
+	parseTime := func(name string, target *time.Time) {
+		value := query.Get(name)
+		if value == "" {
+			return
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: name, Rule: "rfc3339", Message: name + " must be an RFC 3339 timestamp"})
+			return
+		}
+		*target = parsed
+	}
+
+	parseTime("since", &filter.Since)
+	parseTime("until", &filter.Until)
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditQueryLimit {
+			fieldErrors = append(fieldErrors, FieldError{Field: "limit", Rule: "range", Message: "limit must be between 1 and " + strconv.Itoa(maxAuditQueryLimit)})
+		} else {
+			filter.Limit = limit
+		}
+	}
+
+	return filter, fieldErrors
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+const auditQueueContextKey = contextKey("audit_queue")
+
+// Фоновая запись частых событий, которые может вызвать любой клиент
+// без учётных данных (rate_limited, token_rejected). Запись в журнал
+// аудита сериализована, и при потоке таких событий запросы ждали бы
+// друг друга. Если буфер полон, событие отбрасывается, в общий лог
+// оно всё равно попадает
+type AuditQueue struct {
+	queue *asyncQueue[AuditEvent]
+
+	Logger *slog.Logger
+}
+
+func NewAuditQueue(audit IAuditLog, buffer int) *AuditQueue {
+	q := &AuditQueue{}
+	q.queue = newAsyncQueue(buffer, func(event AuditEvent) {
+		if _, err := audit.Record(context.Background(), event); err != nil {
+			loggerOrDiscard(q.Logger).Error("audit event saving error", "component", "audit", "event", event.Type, "error", err)
+		}
+	})
+	return q
+}
+
+func WithAuditQueue(ctx context.Context, queue *AuditQueue) context.Context {
+	return context.WithValue(ctx, auditQueueContextKey, queue)
+}
+
+func (q *AuditQueue) record(event AuditEvent) {
+	q.queue.push(event)
+}
+
+// Сколько событий отброшено из-за переполнения буфера
+func (q *AuditQueue) Dropped() uint64 {
+	return q.queue.Dropped()
+}
+
+// Дописывает оставшиеся в буфере события. События после Close
+// отбрасываются
+func (q *AuditQueue) Close() error {
+	if q.queue.close() {
+		if dropped := q.Dropped(); dropped > 0 {
+			loggerOrDiscard(q.Logger).Warn("audit events dropped", "component", "audit", "count", dropped)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errAuditChainBroken = errors.New("audit chain broken")
+
+// Сколько раз повторять запись события, если база занята дольше
+// busy_timeout другим процессом (CLI или другой экземпляр сервиса)
+const maxAuditBusyRetries = 3
+
+// Журнал аудита в SQLite. Хэш события считается от хэша предыдущего
+// и содержимого события, поэтому правка или удаление записи в базе
+// обнаруживается при проверке цепочки с тем же ключом
+type SQLAuditLog struct {
+	bd  *sql.DB
+	key []byte
+	// Последнее событие читается и новое дописывается под блокировкой
+	// записи базы (BEGIN IMMEDIATE), так что цепочка не разветвится и
+	// при записи из нескольких процессов. Мьютекс лишь избавляет свои
+	// горутины от ожидания этой блокировки
+	mu sync.Mutex
+}
+
+// Хэши считаются HMAC на ключе, выведенном из secret и не хранящемся
+// в базе: без него правку нельзя скрыть, пересчитав хэши следующих
+// событий. С пустым secret цепочка ловит только случайную порчу
+func NewSQLAuditLog(db *sql.DB, secret string) *SQLAuditLog {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("audit-chain"))
+	return &SQLAuditLog{bd: db, key: mac.Sum(nil)}
+}
+
+// То, от чего считается хэш. Ключи details json.Marshal сортирует сам
+type auditHashPayload struct {
+	ID        int64             `json:"id"`
+	Time      int64             `json:"time"`
+	Type      AuditEventType    `json:"type"`
+	Username  string            `json:"username"`
+	IP        string            `json:"ip"`
+	RequestID string            `json:"request_id"`
+	Details   map[string]string `json:"details"`
+}
+
+func auditEventHash(key []byte, event AuditEvent) (string, error) {
+	payload, err := json.Marshal(auditHashPayload{
+		ID:        event.ID,
+		Time:      event.Time.UnixNano(),
+		Type:      event.Type,
+		Username:  event.Username,
+		IP:        event.IP,
+		RequestID: event.RequestID,
+		Details:   normalizeAuditDetails(event.Details),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(event.PrevHash))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Пустые details хранятся и хэшируются как {}
+func normalizeAuditDetails(details map[string]string) map[string]string {
+	if details == nil {
+		return map[string]string{}
+	}
+	return details
+}
+
+func (l *SQLAuditLog) Record(ctx context.Context, event AuditEvent) (AuditEvent, error) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = time.Unix(0, event.Time.UnixNano()).UTC()
+	event.Details = normalizeAuditDetails(event.Details)
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		recorded, err := l.append(ctx, event, string(details))
+		if !isSQLiteBusy(err) || attempt == maxAuditBusyRetries {
+			return recorded, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return AuditEvent{}, err
+		}
+	}
+}
+
+func (l *SQLAuditLog) append(ctx context.Context, event AuditEvent, details string) (AuditEvent, error) {
+	conn, err := l.bd.Conn(ctx)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	defer conn.Close()
+
+	err = immediateTx(ctx, conn, func() error {
+		err := conn.QueryRowContext(ctx, "SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&event.ID, &event.PrevHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		event.ID++
+
+		event.Hash, err = auditEventHash(l.key, event)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.ExecContext(ctx,
+			`INSERT INTO audit_events (id, occurred_at, type, username, ip, request_id, details, prev_hash, hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.ID, event.Time.UnixNano(), event.Type, event.Username, event.IP, event.RequestID, details, event.PrevHash, event.Hash)
+		return err
+	})
+	if err != nil {
+		return AuditEvent{}, err
+	}
+
+	return event, nil
+}
+
+// События от новых к старым. Пустые поля фильтра не ограничивают выборку
+func (l *SQLAuditLog) Query(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	var conditions []string
+	var args []any
+
+	if filter.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, filter.IP)
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, filter.Type)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "occurred_at >= ?")
+		args = append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "occurred_at <= ?")
+		args = append(args, filter.Until.UnixNano())
+	}
+
+	query := "SELECT id, occurred_at, type, username, ip, request_id, details, prev_hash, hash FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := l.bd.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Проходит цепочку с первого события и возвращает число проверенных.
+// Удаление событий с конца цепочки так не обнаружить: для этого нужно
+// сверять последний хэш с сохранённым вне базы
+func (l *SQLAuditLog) Verify(ctx context.Context) (int, string, error) {
+	rows, err := l.bd.QueryContext(ctx,
+		"SELECT id, occurred_at, type, username, ip, request_id, details, prev_hash, hash FROM audit_events ORDER BY id")
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+
+	checked := 0
+	var prevID int64
+	prevHash := ""
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return checked, prevHash, fmt.Errorf("%w: event %d: %v", errAuditChainBroken, prevID+1, err)
+		}
+
+		if event.ID != prevID+1 {
+			return checked, prevHash, fmt.Errorf("%w: event %d is missing", errAuditChainBroken, prevID+1)
+		}
+		if event.PrevHash != prevHash {
+			return checked, prevHash, fmt.Errorf("%w: event %d does not link to event %d", errAuditChainBroken, event.ID, prevID)
+		}
+
+		hash, err := auditEventHash(l.key, event)
+		if err != nil {
+			return checked, prevHash, err
+		}
+		if hash != event.Hash {
+			return checked, prevHash, fmt.Errorf("%w: event %d was modified", errAuditChainBroken, event.ID)
+		}
+
+		checked++
+		prevID, prevHash = event.ID, event.Hash
+	}
+
+	return checked, prevHash, rows.Err()
+}
+
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var event AuditEvent
+	var occurredAt int64
+	var details string
+
+	err := rows.Scan(&event.ID, &occurredAt, &event.Type, &event.Username, &event.IP, &event.RequestID, &details, &event.PrevHash, &event.Hash)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+
+	event.Time = time.Unix(0, occurredAt).UTC()
+	if err := json.Unmarshal([]byte(details), &event.Details); err != nil {
+		return AuditEvent{}, err
+	}
+
+	return event, nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordTestAuditEvents(t *testing.T, audit *SQLAuditLog) time.Time {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	events := []AuditEvent{
+		{Type: AuditRegister, Username: "alice", IP: "203.0.113.7"},
+		{Type: AuditLoginFailure, Username: "alice", IP: "203.0.113.7", Details: map[string]string{"reason": "invalid_password"}},
+		{Type: AuditLoginSuccess, Username: "alice", IP: "203.0.113.7"},
+		{Type: AuditLoginFailure, Username: "bob", IP: "198.51.100.2", Details: map[string]string{"reason": "unknown_user"}},
+		{Type: AuditRateLimited, IP: "198.51.100.2", Details: map[string]string{"path": "/login"}},
+	}
+
+	for i, event := range events {
+		event.Time = start.Add(time.Duration(i) * time.Minute)
+		_, err := audit.Record(ctx, event)
+		require.NoError(t, err)
+	}
+
+	return start
+}
+
+func TestSQLAuditLog_RecordChainsEvents(t *testing.T) {
+	ctx := context.Background()
+	audit := NewSQLAuditLog(newTestDB(t), "")
+
+	first, err := audit.Record(ctx, AuditEvent{Type: AuditRegister, Username: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.ID)
+	assert.Empty(t, first.PrevHash)
+	assert.Len(t, first.Hash, 64)
+
+	second, err := audit.Record(ctx, AuditEvent{Type: AuditLoginSuccess, Username: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), second.ID)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	checked, lastHash, err := audit.Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, checked)
+	assert.Equal(t, second.Hash, lastHash)
+}
+
+// Несколько процессов с одной базой (экземпляры сервиса и CLI) пишут
+// одновременно: ни одно событие не теряется и цепочка не ветвится
+func TestSQLAuditLog_ConcurrentWritersShareChain(t *testing.T) {
+	ctx := context.Background()
+
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "audit.db")
+
+	var logs []*SQLAuditLog
+	for i := 0; i < 4; i++ {
+		db, err := initDB(ctx, &config)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		logs = append(logs, NewSQLAuditLog(db, ""))
+	}
+
+	const perWriter = 20
+	var wg sync.WaitGroup
+	for _, audit := range logs {
+		for i := 0; i < perWriter; i++ {
+			wg.Add(1)
+			go func(audit *SQLAuditLog) {
+				defer wg.Done()
+				_, err := audit.Record(ctx, AuditEvent{Type: AuditLoginSuccess, Username: "alice"})
+				assert.NoError(t, err)
+			}(audit)
+		}
+	}
+	wg.Wait()
+
+	checked, _, err := logs[0].Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(logs)*perWriter, checked)
+}
+
+func TestSQLAuditLog_VerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name          string
+		tamper        string
+		expectedError string
+	}{
+		{
+			name:          "Modified field",
+			tamper:        "UPDATE audit_events SET username = 'mallory' WHERE id = 3",
+			expectedError: "event 3 was modified",
+		},
+		{
+			name:          "Modified details",
+			tamper:        `UPDATE audit_events SET details = '{"reason":"unknown_user"}' WHERE id = 2`,
+			expectedError: "event 2 was modified",
+		},
+		{
+			name:          "Deleted event",
+			tamper:        "DELETE FROM audit_events WHERE id = 2",
+			expectedError: "event 2 is missing",
+		},
+		{
+			name:          "Deleted and renumbered",
+			tamper:        "DELETE FROM audit_events WHERE id = 2; UPDATE audit_events SET id = id - 1 WHERE id > 2",
+			expectedError: "event 2 does not link to event 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			audit := NewSQLAuditLog(db, "")
+			recordTestAuditEvents(t, audit)
+
+			_, err := db.Exec(tt.tamper)
+			require.NoError(t, err)
+
+			_, _, err = audit.Verify(context.Background())
+			require.ErrorIs(t, err, errAuditChainBroken)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
+
+// Тот, у кого есть доступ к базе, но нет audit_key, может пересчитать
+// хэши после правки, но проверку с настоящим ключом это не пройдёт
+func TestSQLAuditLog_KeyedChainDetectsRecomputedHashes(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	audit := NewSQLAuditLog(db, "audit-key-that-is-at-least-32-bytes")
+	recordTestAuditEvents(t, audit)
+
+	_, err := db.Exec("UPDATE audit_events SET username = 'mallory' WHERE id = 3")
+	require.NoError(t, err)
+
+	// Пересчёт цепочки без настоящего ключа
+	forger := NewSQLAuditLog(db, "")
+	rows, err := db.Query("SELECT id, occurred_at, type, username, ip, request_id, details, prev_hash, hash FROM audit_events ORDER BY id")
+	require.NoError(t, err)
+	var events []AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		require.NoError(t, err)
+		events = append(events, event)
+	}
+	require.NoError(t, rows.Close())
+
+	prevHash := ""
+	for _, event := range events {
+		event.PrevHash = prevHash
+		event.Hash, err = auditEventHash(forger.key, event)
+		require.NoError(t, err)
+		_, err = db.Exec("UPDATE audit_events SET prev_hash = ?, hash = ? WHERE id = ?", event.PrevHash, event.Hash, event.ID)
+		require.NoError(t, err)
+		prevHash = event.Hash
+	}
+
+	checked, _, err := forger.Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(events), checked)
+
+	_, _, err = audit.Verify(ctx)
+	require.ErrorIs(t, err, errAuditChainBroken)
+	assert.Contains(t, err.Error(), "event 1 was modified")
+}
+
+func TestSQLAuditLog_Query(t *testing.T) {
+	audit := NewSQLAuditLog(newTestDB(t), "")
+	start := recordTestAuditEvents(t, audit)
+
+	tests := []struct {
+		name     string
+		filter   AuditFilter
+		expected []int64
+	}{
+		{name: "All, newest first", filter: AuditFilter{}, expected: []int64{5, 4, 3, 2, 1}},
+		{name: "By username", filter: AuditFilter{Username: "alice"}, expected: []int64{3, 2, 1}},
+		{name: "By IP", filter: AuditFilter{IP: "198.51.100.2"}, expected: []int64{5, 4}},
+		{name: "By type", filter: AuditFilter{Type: AuditLoginFailure}, expected: []int64{4, 2}},
+		{name: "By time range", filter: AuditFilter{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)}, expected: []int64{4, 3, 2}},
+		{name: "Combined", filter: AuditFilter{Username: "alice", Type: AuditLoginFailure}, expected: []int64{2}},
+		{name: "Limit", filter: AuditFilter{Limit: 2}, expected: []int64{5, 4}},
+		{name: "No match", filter: AuditFilter{Username: "carol"}, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := audit.Query(context.Background(), tt.filter)
+			require.NoError(t, err)
+
+			var ids []int64
+			for _, event := range events {
+				ids = append(ids, event.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	events, err := audit.Query(context.Background(), AuditFilter{Type: AuditRateLimited})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, map[string]string{"path": "/login"}, events[0].Details)
+	assert.Equal(t, start.Add(4*time.Minute), events[0].Time)
+}
+
+func TestAuditHandler(t *testing.T) {
+	audit := NewSQLAuditLog(newTestDB(t), "")
+	recordTestAuditEvents(t, audit)
+	handler := AuditHandler{Audit: audit}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		expectedStatus int
+		expectedIDs    []int64
+		expectedField  string
+	}{
+		{
+			name:           "Filter by user and type",
+			method:         http.MethodGet,
+			url:            "/admin/audit?username=alice&type=login_failure",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int64{2},
+		},
+		{
+			name:           "Filter by time range",
+			method:         http.MethodGet,
+			url:            "/admin/audit?since=2024-03-10T12:03:00Z&until=2024-03-10T13:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int64{5, 4},
+		},
+		{
+			name:           "Empty result",
+			method:         http.MethodGet,
+			url:            "/admin/audit?ip=192.0.2.1",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int64{},
+		},
+		{
+			name:           "Invalid since",
+			method:         http.MethodGet,
+			url:            "/admin/audit?since=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedField:  "since",
+		},
+		{
+			name:           "Invalid limit",
+			method:         http.MethodGet,
+			url:            "/admin/audit?limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedField:  "limit",
+		},
+		{
+			name:           "Wrong method",
+			method:         http.MethodPost,
+			url:            "/admin/audit",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := executeHandler(handler.auditHandler, httptest.NewRequest(tt.method, tt.url, nil))
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedIDs != nil {
+				var response AuditQueryResponse
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+				ids := []int64{}
+				for _, event := range response.Events {
+					ids = append(ids, event.ID)
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+			}
+
+			if tt.expectedField != "" {
+				var response ErrorResponse
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				require.Len(t, response.Error.Fields, 1)
+				assert.Equal(t, tt.expectedField, response.Error.Fields[0].Field)
+			}
+		})
+	}
+}
+
+func TestRecordAudit_FromRequestContext(t *testing.T) {
+	audit := NewSQLAuditLog(newTestDB(t), "")
+	keys := NewHMACKeyManager([]byte("test-secret-key-that-is-long-enough"))
+
+	queue := NewAuditQueue(audit, 16)
+
+	handler := RequestIDMiddleware(ClientIPMiddleware(&TrustedProxies{},
+		AuditMiddleware(audit, queue, middelwareHandler(secretHandler, keys, nil))))
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("Authorization", "Bearer not.a.token")
+	req.Header.Set(requestIDHeader, "req-42")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Отказ в токене пишется в фоне, Close дожидается записи
+	require.NoError(t, queue.Close())
+
+	events, err := audit.Query(context.Background(), AuditFilter{Type: AuditTokenRejected})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "203.0.113.7", events[0].IP)
+	assert.Equal(t, "req-42", events[0].RequestID)
+	assert.Equal(t, "invalid", events[0].Details["reason"])
+}
+
+// Журнал, запись в который ждёт, пока тест не откроет gate
+type blockingAuditLog struct {
+	gate     chan struct{}
+	recorded atomic.Int32
+}
+
+func (a *blockingAuditLog) Record(ctx context.Context, event AuditEvent) (AuditEvent, error) {
+	<-a.gate
+	a.recorded.Add(1)
+	return event, nil
+}
+
+func (a *blockingAuditLog) Query(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	return nil, nil
+}
+
+func TestAuditQueue_RateLimitedDoesNotBlockRequests(t *testing.T) {
+	audit := &blockingAuditLog{gate: make(chan struct{})}
+	queue := NewAuditQueue(audit, 2)
+
+	limiter := NewRateLimiter(1, time.Minute)
+	handler := AuditMiddleware(audit, queue, RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/login", nil))
+		}
+	}()
+
+	// Отказы лимитера не ждут записи в журнал аудита
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("requests blocked on the audit log")
+	}
+
+	close(audit.gate)
+	require.NoError(t, queue.Close())
+
+	// 9 отказов: два в буфере, ещё одно мог успеть взять писатель,
+	// остальные отброшены
+	assert.GreaterOrEqual(t, audit.recorded.Load(), int32(2))
+	assert.Equal(t, uint64(9), queue.Dropped()+uint64(audit.recorded.Load()))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPContextKey = contextKey("client_ip")
+
+// Прокси, чьим заголовкам о клиенте можно доверять. Без доверенных
+// прокси клиентом считается адрес TCP соединения: заголовки может
+// подставить кто угодно
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// Принимает CIDR или отдельные адреса
+func ParseTrustedProxies(values []string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			addr = addr.Unmap()
+			proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (p *TrustedProxies) trusted(addr netip.Addr) bool {
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Адрес клиента. Цепочка из Forwarded или X-Forwarded-For читается
+// справа налево: первый адрес, не принадлежащий доверенному прокси,
+// и есть клиент. Левее него значения мог подставить сам клиент
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	remote, ok := parseHostAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+
+	if !p.trusted(remote) {
+		return remote.String()
+	}
+
+	var chain []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwardedFor(strings.Join(forwarded, ","))
+	} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		chain = strings.Split(strings.Join(xff, ","), ",")
+	} else if realIP, ok := parseHostAddr(r.Header.Get("X-Real-IP")); ok {
+		return realIP.String()
+	}
+
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop, ok := parseHostAddr(chain[i])
+		if !ok {
+			// Мусор или "unknown": дальше цепочке верить нельзя
+			break
+		}
+
+		client = hop
+		if !p.trusted(hop) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// Для каждого элемента Forwarded (RFC 7239) значение параметра for
+func parseForwardedFor(header string) []string {
+	var chain []string
+
+	for _, element := range strings.Split(header, ",") {
+		value := ""
+		for _, pair := range strings.Split(element, ";") {
+			name, v, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(name, "for") {
+				value = strings.Trim(v, `"`)
+			}
+		}
+		chain = append(chain, value)
+	}
+
+	return chain
+}
+
+// Адрес без порта: "203.0.113.7", "203.0.113.7:1234", "[2001:db8::1]:443"
+func parseHostAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap().WithZone(""), true
+}
+
+// Определяет адрес клиента один раз на запрос
+func ClientIPMiddleware(proxies *TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey, proxies.ClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+
+	if addr, ok := parseHostAddr(r.RemoteAddr); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.10"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expectedIP string
+	}{
+		{
+			name:       "Direct client, port stripped",
+			remoteAddr: "203.0.113.7:51234",
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "Untrusted peer cannot spoof X-Forwarded-For",
+			remoteAddr: "203.0.113.7:51234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4"}},
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "Trusted proxy",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.20"}},
+			expectedIP: "198.51.100.20",
+		},
+		{
+			name:       "Spoofed entries left of the real client are ignored",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.20, 10.0.0.9"}},
+			expectedIP: "198.51.100.20",
+		},
+		{
+			name:       "Several header lines",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.20:8080"}},
+			expectedIP: "198.51.100.20",
+		},
+		{
+			name:       "Only trusted hops",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.1.1.1, 10.2.2.2"}},
+			expectedIP: "10.1.1.1",
+		},
+		{
+			name:       "Garbage stops the chain",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.20, nonsense, 10.0.0.9"}},
+			expectedIP: "10.0.0.9",
+		},
+		{
+			name:       "Forwarded header",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https, for=192.0.2.60;by=10.0.0.5`}},
+			expectedIP: "192.0.2.60",
+		},
+		{
+			name:       "Forwarded takes precedence over X-Forwarded-For",
+			remoteAddr: "10.0.0.5:443",
+			headers: map[string][]string{
+				"Forwarded":       {"For=198.51.100.20"},
+				"X-Forwarded-For": {"1.2.3.4"},
+			},
+			expectedIP: "198.51.100.20",
+		},
+		{
+			name:       "Forwarded unknown",
+			remoteAddr: "10.0.0.5:443",
+			headers:    map[string][]string{"Forwarded": {"for=unknown"}},
+			expectedIP: "10.0.0.5",
+		},
+		{
+			name:       "X-Real-IP from trusted proxy",
+			remoteAddr: "192.0.2.10:443",
+			headers:    map[string][]string{"X-Real-IP": {"198.51.100.20"}},
+			expectedIP: "198.51.100.20",
+		},
+		{
+			name:       "X-Real-IP from untrusted peer",
+			remoteAddr: "203.0.113.7:51234",
+			headers:    map[string][]string{"X-Real-IP": {"198.51.100.20"}},
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "IPv6 peer",
+			remoteAddr: "[2001:db8::1]:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db9::abcd"}},
+			expectedIP: "2001:db9::abcd",
+		},
+		{
+			name:       "IPv4-mapped IPv6 peer",
+			remoteAddr: "[::ffff:203.0.113.7]:51234",
+			expectedIP: "203.0.113.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			assert.Equal(t, tt.expectedIP, proxies.ClientIP(req))
+		})
+	}
+}
+
+func TestParseTrustedProxies_Invalid(t *testing.T) {
+	_, err := ParseTrustedProxies([]string{"10.0.0.0/8", "not-a-network"})
+	assert.Error(t, err)
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	var seen string
+	handler := ClientIPMiddleware(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = getClientIP(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:443"
+	req.Header.Set("X-Forwarded-For", "198.51.100.20")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "198.51.100.20", seen)
+}
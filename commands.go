@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+func runCommand(ctx context.Context, config *Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(ctx, config, args[1:])
+	case "unlock":
+		return runUnlockCommand(ctx, config, args[1:])
+	case "grant-role":
+		return runGrantRoleCommand(ctx, config, args[1:])
+	case "rotate-key":
+		return runRotateKeyCommand(config, args[1:])
+	case "retire-key":
+		return runRetireKeyCommand(config, args[1:])
+	case "verify-audit":
+		return runVerifyAuditCommand(ctx, config, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// migrate up | down [N] | status | version
+func runMigrateCommand(ctx context.Context, config *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [N] | status | version")
+	}
+
+	db, err := openDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Printf("Applied %d migrations\n", applied)
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+		fmt.Printf("Reverted %d migrations\n", reverted)
+		return err
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-28s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(version)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// unlock <username> — снятие блокировки без HTTP, например когда заблокирован сам админ
+func runUnlockCommand(ctx context.Context, config *Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: unlock <username>")
+	}
+
+	db, err := initDB(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx = WithAuditLog(ctx, NewSQLAuditLog(db, config.AuditKey))
+	lockout := AccountLockout{Repo: &SQLRepository{bd: db}, Policy: config.LockoutPolicy()}
+	if err := lockout.Unlock(ctx, args[0], "cli"); err != nil {
+		return err
+	}
+
+	fmt.Printf("User %s unlocked\n", args[0])
+	return nil
+}
+
+// grant-role <username> <role> — нужен, чтобы назначить первого администратора
+func runGrantRoleCommand(ctx context.Context, config *Config, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: grant-role <username> <role>")
+	}
+
+	db, err := initDB(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx = WithAuditLog(ctx, NewSQLAuditLog(db, config.AuditKey))
+	repository := SQLRepository{bd: db}
+	if err := repository.AssignRole(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+
+	recordAudit(ctx, AuditEvent{Type: AuditRoleGranted, Username: args[0], Details: map[string]string{"role": args[1], "by": "cli"}})
+	fmt.Printf("Role %s granted to %s\n", args[1], args[0])
+	return nil
+}
+
+// verify-audit — проверяет цепочку хэшей журнала аудита. Последний хэш
+// стоит сохранять вне базы: так обнаружится и удаление последних событий
+func runVerifyAuditCommand(ctx context.Context, config *Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: verify-audit")
+	}
+
+	db, err := initDB(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	checked, lastHash, err := NewSQLAuditLog(db, config.AuditKey).Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("%d events verified before failure: %w", checked, err)
+	}
+
+	fmt.Printf("%d events verified, last hash %s\n", checked, lastHash)
+	return nil
+}
+
+// rotate-key — создаёт новый ключ подписи в jwt_key_dir. Запущенные
+// экземпляры подхватят его при перечитывании каталога, а подписывать
+// он начнёт через jwt_key_activation_delay
+func runRotateKeyCommand(config *Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: rotate-key")
+	}
+
+	keys, err := initKeys(config)
+	if err != nil {
+		return err
+	}
+
+	kid, err := keys.Rotate()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("New signing key %s, signs new tokens after %s\n", kid, config.JWTKeyActivationDelay)
+	return nil
+}
+
+// retire-key <kid> — удаляет старый ключ. Токены, подписанные им,
+// перестанут проверяться, поэтому удалять стоит после их истечения
+func runRetireKeyCommand(config *Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: retire-key <kid>")
+	}
+
+	keys, err := initKeys(config)
+	if err != nil {
+		return err
+	}
+
+	if err := keys.Retire(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Key %s retired\n", args[0])
+	return nil
+}
@@ -1,22 +1,709 @@
 package main
 
-import "time"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix       = "AUTH_"
+	minHMACKeyBytes = 32
+
+	// Верхние границы argon2id: больше — уже не защита, а отказ в обслуживании
+	maxArgon2Memory      = 4 * 1024 * 1024 // 4 ГиБ в КиБ
+	maxArgon2Iterations  = 64
+	maxArgon2Parallelism = 64
+
+	LoginResponseJSON = "json"
+	LoginResponseRaw  = "raw"
+)
 
 type Config struct {
-	JWTKey     string
-	Port       string
-	DBPath     string
-	RateLimit  int           // Максимальное количество запросов
-	RateWindow time.Duration // Временное окно для rate limiting
+	JWTKey     string        `yaml:"jwt_key"`
+	Port       string        `yaml:"port"`
+	DBPath     string        `yaml:"db_path"`
+	RateLimit  int           `yaml:"rate_limit"`  // Максимальное количество запросов
+	RateWindow time.Duration `yaml:"rate_window"` // Временное окно для rate limiting
+
+	ReadTimeout       time.Duration `yaml:"read_timeout"`        // Чтение всего запроса вместе с телом
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"` // Чтение заголовков, защита от slowloris
+	WriteTimeout      time.Duration `yaml:"write_timeout"`       // От конца заголовков запроса до конца ответа
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`        // Простой keep-alive соединения
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`    // Сколько ждать текущие запросы при остановке
+
+	RateLimitAlgorithm string                     `yaml:"rate_limit_algorithm"` // token_bucket, gcra или sliding_window
+	RateLimitRoutes    map[string]RateLimitPolicy `yaml:"rate_limit_routes"`    // Свои лимиты для отдельных путей
+
+	LogFormat string `yaml:"log_format"` // json или text
+	LogLevel  string `yaml:"log_level"`  // debug, info, warn или error
+	LogFile   string `yaml:"log_file"`   // Копия логов в файл, пусто — только stdout
+
+	LogMaxSize     int           `yaml:"log_max_size"`     // МБ до ротации, 0 — без ограничения
+	LogRotateDaily bool          `yaml:"log_rotate_daily"` // Ротация при смене суток
+	LogCompress    bool          `yaml:"log_compress"`     // gzip для ротированных файлов
+	LogMaxAge      time.Duration `yaml:"log_max_age"`      // Сколько хранить ротированные файлы, 0 — бессрочно
+	LogMaxBackups  int           `yaml:"log_max_backups"`  // Сколько ротированных файлов хранить, 0 — все
+
+	AccessLogPath   string `yaml:"access_log_path"`   // Журнал запросов JSON Lines, пусто — выключен
+	AccessLogBuffer int    `yaml:"access_log_buffer"` // Записей в очереди до отбрасывания
+
+	AuditQueueBuffer int    `yaml:"audit_queue_buffer"` // Событий rate_limited и token_rejected в очереди до отбрасывания
+	AuditKey         string `yaml:"audit_key"`          // Секрет цепочки аудита; без него цепочку может пересчитать любой с доступом к базе
+
+	TrustedProxies []string `yaml:"trusted_proxies"` // CIDR прокси, которым можно верить в X-Forwarded-For/Forwarded
+
+	RateLimitStore           string        `yaml:"rate_limit_store"`            // memory, sqlite или redis
+	RateLimitMaxKeys         int           `yaml:"rate_limit_max_keys"`         // Потолок ключей в памяти, 0 — без ограничения
+	RateLimitCleanupInterval time.Duration `yaml:"rate_limit_cleanup_interval"` // Период удаления просроченных ключей
+	RedisAddr                string        `yaml:"redis_addr"`                  // host:port для rate_limit_store: redis
+	RedisPassword            string        `yaml:"redis_password"`
+
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`  // Время жизни access токена
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"` // Время жизни refresh токена
+
+	LoginResponseFormat string `yaml:"login_response_format"` // json или raw (сырой токен для старых клиентов)
+	CookieSessions      bool   `yaml:"cookie_sessions"`       // Сессия в HttpOnly cookie по запросу клиента
+
+	JWTAlgorithm          string        `yaml:"jwt_algorithm"`            // HS256, RS256 или EdDSA
+	JWTKeyDir             string        `yaml:"jwt_key_dir"`              // Каталог с PEM ключами для RS256/EdDSA
+	JWTKeyReloadInterval  time.Duration `yaml:"jwt_key_reload_interval"`  // Как часто перечитывать jwt_key_dir, 0 — только по SIGHUP
+	JWTKeyActivationDelay time.Duration `yaml:"jwt_key_activation_delay"` // Сколько новый ключ только публикуется, прежде чем подписывать
+
+	JWTIssuer   string        `yaml:"jwt_issuer"`   // Claim iss, пусто — не проверяется
+	JWTAudience string        `yaml:"jwt_audience"` // Claim aud, пусто — не проверяется
+	JWTLeeway   time.Duration `yaml:"jwt_leeway"`   // Допуск расхождения часов для exp/nbf/iat
+
+	LockoutThreshold   int           `yaml:"lockout_threshold"`    // Неудачных попыток до блокировки, 0 — выключено
+	LockoutDuration    time.Duration `yaml:"lockout_duration"`     // Первая блокировка
+	LockoutMaxDuration time.Duration `yaml:"lockout_max_duration"` // Потолок для экспоненциального роста
+
+	MFAEncryptionKey string `yaml:"mfa_encryption_key"` // base64, 32 байта; без него 2FA недоступна
+	MFAIssuer        string `yaml:"mfa_issuer"`         // Название сервиса в приложении-аутентификаторе
+
+	PasswordMinLength    int    `yaml:"password_min_length"`
+	PasswordMinClasses   int    `yaml:"password_min_classes"`   // Сколько классов символов из 4 обязательно
+	BreachedPasswordsDir string `yaml:"breached_passwords_dir"` // Файлы диапазонов Pwned Passwords, пусто — проверка выключена
+
+	PasswordHasher    string `yaml:"password_hasher"` // argon2id или bcrypt
+	BcryptCost        int    `yaml:"bcrypt_cost"`
+	Argon2Memory      uint32 `yaml:"argon2_memory"` // KiB
+	Argon2Iterations  uint32 `yaml:"argon2_iterations"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism"`
 }
 
 // Дефолтная конфигурация
 func DefaultConfig() Config {
 	return Config{
-		JWTKey:     "secretKey",
 		Port:       "8888",
 		DBPath:     "./users.db",
 		RateLimit:  100,         // 100 запросов
 		RateWindow: time.Minute, // в течение 1 минуты
+
+		ReadTimeout:       10 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   15 * time.Second,
+
+		LogFormat: LogFormatJSON,
+		LogLevel:  "info",
+		LogFile:   "app.log",
+
+		LogMaxSize:     100,
+		LogRotateDaily: true,
+		LogCompress:    true,
+		LogMaxAge:      30 * 24 * time.Hour,
+		LogMaxBackups:  30,
+
+		AccessLogPath:   "requests.jsonl",
+		AccessLogBuffer: 1024,
+
+		AuditQueueBuffer: 1024,
+
+		RateLimitAlgorithm: AlgorithmSlidingWindow,
+		RateLimitRoutes: map[string]RateLimitPolicy{
+			// Регистрация: не больше 5 аккаунтов в час с одного адреса
+			"/register": {Algorithm: AlgorithmGCRA, Limit: 5, Period: time.Hour, Burst: 2},
+		},
+
+		RateLimitStore:           RateLimitStoreMemory,
+		RateLimitMaxKeys:         100000,
+		RateLimitCleanupInterval: time.Minute,
+
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+
+		LoginResponseFormat: LoginResponseJSON,
+
+		JWTAlgorithm:          "RS256",
+		JWTKeyDir:             "./keys",
+		JWTKeyReloadInterval:  time.Minute,
+		JWTKeyActivationDelay: 5 * time.Minute,
+		JWTLeeway:             30 * time.Second,
+
+		LockoutThreshold:   5,
+		LockoutDuration:    15 * time.Minute,
+		LockoutMaxDuration: 24 * time.Hour,
+
+		MFAIssuer: "WebAutorize",
+
+		PasswordMinLength:  8,
+		PasswordMinClasses: 2,
+
+		PasswordHasher:    HasherArgon2id,
+		BcryptCost:        bcrypt.DefaultCost,
+		Argon2Memory:      DefaultArgon2Params().Memory,
+		Argon2Iterations:  DefaultArgon2Params().Iterations,
+		Argon2Parallelism: DefaultArgon2Params().Parallelism,
+	}
+}
+
+// Собирает конфигурацию по слоям: значения по умолчанию → файл (YAML или JSON,
+// путь из -config или AUTH_CONFIG) → переменные окружения AUTH_* → флаги.
+// Возвращает аргументы, оставшиеся после флагов (подкоманду)
+func LoadConfig(args []string) (Config, []string, error) {
+	return loadConfig(args, os.Getenv)
+}
+
+func loadConfig(args []string, getenv func(string) string) (Config, []string, error) {
+	config := DefaultConfig()
+
+	fs := flag.NewFlagSet("auth", flag.ContinueOnError)
+	configPath := fs.String("config", getenv(envPrefix+"CONFIG"), "path to YAML or JSON config file")
+	overrides := registerConfigFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return config, nil, err
+	}
+
+	if *configPath != "" {
+		if err := config.loadFile(*configPath); err != nil {
+			return config, nil, err
+		}
+	}
+
+	if err := config.loadEnv(getenv); err != nil {
+		return config, nil, err
+	}
+
+	// Применяем только явно переданные флаги, иначе их значения
+	// по умолчанию затрут файл и окружение
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := overrides[f.Name]; ok {
+			apply(&config)
+		}
+	})
+
+	return config, fs.Args(), config.Validate()
+}
+
+// YAML — надмножество JSON, поэтому один парсер читает оба формата
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	var errs []error
+
+	setString := func(name string, target *string) {
+		if value := getenv(envPrefix + name); value != "" {
+			*target = value
+		}
+	}
+
+	setInt := func(name string, target *int) {
+		if value := getenv(envPrefix + name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	// Отрицательные и не влезающие в bitSize значения — ошибка, а не переполнение
+	setUint := func(name string, bitSize int, set func(uint64)) {
+		if value := getenv(envPrefix + name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, bitSize)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			set(parsed)
+		}
+	}
+
+	setBool := func(name string, target *bool) {
+		if value := getenv(envPrefix + name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	setList := func(name string, target *[]string) {
+		if value := getenv(envPrefix + name); value != "" {
+			*target = splitList(value)
+		}
+	}
+
+	setDuration := func(name string, target *time.Duration) {
+		if value := getenv(envPrefix + name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	setString("JWT_KEY", &c.JWTKey)
+	setString("PORT", &c.Port)
+	setString("DB_PATH", &c.DBPath)
+	setInt("RATE_LIMIT", &c.RateLimit)
+	setDuration("RATE_WINDOW", &c.RateWindow)
+	setDuration("READ_TIMEOUT", &c.ReadTimeout)
+	setDuration("READ_HEADER_TIMEOUT", &c.ReadHeaderTimeout)
+	setDuration("WRITE_TIMEOUT", &c.WriteTimeout)
+	setDuration("IDLE_TIMEOUT", &c.IdleTimeout)
+	setDuration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	setString("RATE_LIMIT_ALGORITHM", &c.RateLimitAlgorithm)
+	setString("LOG_FORMAT", &c.LogFormat)
+	setString("LOG_LEVEL", &c.LogLevel)
+	setString("LOG_FILE", &c.LogFile)
+	setInt("LOG_MAX_SIZE", &c.LogMaxSize)
+	setBool("LOG_ROTATE_DAILY", &c.LogRotateDaily)
+	setBool("LOG_COMPRESS", &c.LogCompress)
+	setDuration("LOG_MAX_AGE", &c.LogMaxAge)
+	setInt("LOG_MAX_BACKUPS", &c.LogMaxBackups)
+	setString("ACCESS_LOG_PATH", &c.AccessLogPath)
+	setInt("ACCESS_LOG_BUFFER", &c.AccessLogBuffer)
+	setInt("AUDIT_QUEUE_BUFFER", &c.AuditQueueBuffer)
+	setString("AUDIT_KEY", &c.AuditKey)
+	setList("TRUSTED_PROXIES", &c.TrustedProxies)
+	setString("RATE_LIMIT_STORE", &c.RateLimitStore)
+	setInt("RATE_LIMIT_MAX_KEYS", &c.RateLimitMaxKeys)
+	setDuration("RATE_LIMIT_CLEANUP_INTERVAL", &c.RateLimitCleanupInterval)
+	setString("REDIS_ADDR", &c.RedisAddr)
+	setString("REDIS_PASSWORD", &c.RedisPassword)
+	setDuration("ACCESS_TOKEN_TTL", &c.AccessTokenTTL)
+	setDuration("REFRESH_TOKEN_TTL", &c.RefreshTokenTTL)
+	setString("LOGIN_RESPONSE_FORMAT", &c.LoginResponseFormat)
+	setBool("COOKIE_SESSIONS", &c.CookieSessions)
+	setString("JWT_ALGORITHM", &c.JWTAlgorithm)
+	setString("JWT_KEY_DIR", &c.JWTKeyDir)
+	setDuration("JWT_KEY_RELOAD_INTERVAL", &c.JWTKeyReloadInterval)
+	setDuration("JWT_KEY_ACTIVATION_DELAY", &c.JWTKeyActivationDelay)
+	setString("JWT_ISSUER", &c.JWTIssuer)
+	setString("JWT_AUDIENCE", &c.JWTAudience)
+	setDuration("JWT_LEEWAY", &c.JWTLeeway)
+	setInt("LOCKOUT_THRESHOLD", &c.LockoutThreshold)
+	setDuration("LOCKOUT_DURATION", &c.LockoutDuration)
+	setDuration("LOCKOUT_MAX_DURATION", &c.LockoutMaxDuration)
+	setString("MFA_ENCRYPTION_KEY", &c.MFAEncryptionKey)
+	setString("MFA_ISSUER", &c.MFAIssuer)
+	setInt("PASSWORD_MIN_LENGTH", &c.PasswordMinLength)
+	setInt("PASSWORD_MIN_CLASSES", &c.PasswordMinClasses)
+	setString("BREACHED_PASSWORDS_DIR", &c.BreachedPasswordsDir)
+	setString("PASSWORD_HASHER", &c.PasswordHasher)
+	setInt("BCRYPT_COST", &c.BcryptCost)
+
+	setUint("ARGON2_MEMORY", 32, func(v uint64) { c.Argon2Memory = uint32(v) })
+	setUint("ARGON2_ITERATIONS", 32, func(v uint64) { c.Argon2Iterations = uint32(v) })
+	setUint("ARGON2_PARALLELISM", 8, func(v uint64) { c.Argon2Parallelism = uint8(v) })
+
+	return errors.Join(errs...)
+}
+
+func registerConfigFlags(fs *flag.FlagSet) map[string]func(*Config) {
+	jwtKey := fs.String("jwt-key", "", "HMAC secret for HS256 tokens")
+	port := fs.String("port", "", "HTTP port")
+	dbPath := fs.String("db", "", "path to SQLite database")
+	rateLimit := fs.Int("rate-limit", 0, "max requests per window")
+	rateWindow := fs.Duration("rate-window", 0, "rate limiting window")
+	readTimeout := fs.Duration("read-timeout", 0, "max time to read a whole request")
+	readHeaderTimeout := fs.Duration("read-header-timeout", 0, "max time to read request headers")
+	writeTimeout := fs.Duration("write-timeout", 0, "max time to write a response")
+	idleTimeout := fs.Duration("idle-timeout", 0, "keep-alive connection idle timeout")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long shutdown waits for in-flight requests")
+	rateLimitAlgorithm := fs.String("rate-limit-algorithm", "", "default rate limit algorithm: token_bucket, gcra or sliding_window")
+	rateLimitStore := fs.String("rate-limit-store", "", "rate limit state backend: memory, sqlite or redis")
+	logFormat := fs.String("log-format", "", "log format: json or text")
+	logLevel := fs.String("log-level", "", "minimum log level: debug, info, warn or error")
+	logFile := fs.String("log-file", "", "file that receives a copy of the logs")
+	logMaxSize := fs.Int("log-max-size", 0, "log file size in MB that triggers rotation, 0 disables")
+	logRotateDaily := fs.Bool("log-rotate-daily", false, "rotate the log file when the day changes")
+	logCompress := fs.Bool("log-compress", false, "gzip rotated log files")
+	logMaxAge := fs.Duration("log-max-age", 0, "delete rotated log files older than this, 0 keeps them")
+	logMaxBackups := fs.Int("log-max-backups", 0, "number of rotated log files to keep, 0 keeps all")
+	accessLogPath := fs.String("access-log-path", "", "JSON Lines access log file, empty disables")
+	accessLogBuffer := fs.Int("access-log-buffer", 0, "access log entries queued before dropping")
+	auditQueueBuffer := fs.Int("audit-queue-buffer", 0, "rate_limited and token_rejected audit events queued before dropping")
+	auditKey := fs.String("audit-key", "", "secret keying the audit log hash chain")
+	trustedProxies := fs.String("trusted-proxies", "", "comma-separated CIDRs of trusted reverse proxies")
+	rateLimitMaxKeys := fs.Int("rate-limit-max-keys", 0, "max rate limit keys kept in memory, 0 for unlimited")
+	rateLimitCleanup := fs.Duration("rate-limit-cleanup-interval", 0, "how often expired rate limit keys are removed")
+	redisAddr := fs.String("redis-addr", "", "Redis address for the redis rate limit store")
+	redisPassword := fs.String("redis-password", "", "Redis password")
+	accessTTL := fs.Duration("access-token-ttl", 0, "access token lifetime")
+	refreshTTL := fs.Duration("refresh-token-ttl", 0, "refresh token lifetime")
+	loginResponseFormat := fs.String("login-response-format", "", "login response body: json or raw")
+	cookieSessions := fs.Bool("cookie-sessions", false, "allow HttpOnly cookie sessions")
+	algorithm := fs.String("jwt-algorithm", "", "token signing algorithm: HS256, RS256 or EdDSA")
+	keyDir := fs.String("jwt-key-dir", "", "directory with PEM signing keys")
+	keyReloadInterval := fs.Duration("jwt-key-reload-interval", 0, "how often to re-read jwt-key-dir, 0 to reload only on SIGHUP")
+	keyActivationDelay := fs.Duration("jwt-key-activation-delay", 0, "how long a new key is only published before it signs tokens")
+	issuer := fs.String("jwt-issuer", "", "token issuer (iss claim)")
+	audience := fs.String("jwt-audience", "", "token audience (aud claim)")
+	leeway := fs.Duration("jwt-leeway", 0, "allowed clock skew when validating tokens")
+	lockoutThreshold := fs.Int("lockout-threshold", 0, "failed logins before account lockout, 0 disables")
+	lockoutDuration := fs.Duration("lockout-duration", 0, "first account lockout duration")
+	lockoutMaxDuration := fs.Duration("lockout-max-duration", 0, "maximum account lockout duration")
+	mfaKey := fs.String("mfa-encryption-key", "", "base64 32-byte key for TOTP secrets")
+	mfaIssuer := fs.String("mfa-issuer", "", "issuer name shown in authenticator apps")
+	passwordMinLength := fs.Int("password-min-length", 0, "minimum password length")
+	passwordMinClasses := fs.Int("password-min-classes", 0, "required character classes (0-4)")
+	breachedDir := fs.String("breached-passwords-dir", "", "directory with Pwned Passwords range files")
+	passwordHasher := fs.String("password-hasher", "", "password hashing algorithm: argon2id or bcrypt")
+	bcryptCost := fs.Int("bcrypt-cost", 0, "bcrypt cost factor")
+	argon2Memory := &uintFlag{bitSize: 32}
+	argon2Iterations := &uintFlag{bitSize: 32}
+	argon2Parallelism := &uintFlag{bitSize: 8}
+	fs.Var(argon2Memory, "argon2-memory", "argon2id memory in KiB")
+	fs.Var(argon2Iterations, "argon2-iterations", "argon2id iterations")
+	fs.Var(argon2Parallelism, "argon2-parallelism", "argon2id parallelism")
+
+	return map[string]func(*Config){
+		"jwt-key":           func(c *Config) { c.JWTKey = *jwtKey },
+		"port":              func(c *Config) { c.Port = *port },
+		"db":                func(c *Config) { c.DBPath = *dbPath },
+		"rate-limit":        func(c *Config) { c.RateLimit = *rateLimit },
+		"rate-window":       func(c *Config) { c.RateWindow = *rateWindow },
+		"access-token-ttl":  func(c *Config) { c.AccessTokenTTL = *accessTTL },
+		"refresh-token-ttl": func(c *Config) { c.RefreshTokenTTL = *refreshTTL },
+
+		"read-timeout":                func(c *Config) { c.ReadTimeout = *readTimeout },
+		"read-header-timeout":         func(c *Config) { c.ReadHeaderTimeout = *readHeaderTimeout },
+		"write-timeout":               func(c *Config) { c.WriteTimeout = *writeTimeout },
+		"idle-timeout":                func(c *Config) { c.IdleTimeout = *idleTimeout },
+		"shutdown-timeout":            func(c *Config) { c.ShutdownTimeout = *shutdownTimeout },
+		"rate-limit-algorithm":        func(c *Config) { c.RateLimitAlgorithm = *rateLimitAlgorithm },
+		"rate-limit-store":            func(c *Config) { c.RateLimitStore = *rateLimitStore },
+		"log-format":                  func(c *Config) { c.LogFormat = *logFormat },
+		"log-level":                   func(c *Config) { c.LogLevel = *logLevel },
+		"log-file":                    func(c *Config) { c.LogFile = *logFile },
+		"log-max-size":                func(c *Config) { c.LogMaxSize = *logMaxSize },
+		"log-rotate-daily":            func(c *Config) { c.LogRotateDaily = *logRotateDaily },
+		"log-compress":                func(c *Config) { c.LogCompress = *logCompress },
+		"log-max-age":                 func(c *Config) { c.LogMaxAge = *logMaxAge },
+		"log-max-backups":             func(c *Config) { c.LogMaxBackups = *logMaxBackups },
+		"access-log-path":             func(c *Config) { c.AccessLogPath = *accessLogPath },
+		"access-log-buffer":           func(c *Config) { c.AccessLogBuffer = *accessLogBuffer },
+		"audit-queue-buffer":          func(c *Config) { c.AuditQueueBuffer = *auditQueueBuffer },
+		"audit-key":                   func(c *Config) { c.AuditKey = *auditKey },
+		"trusted-proxies":             func(c *Config) { c.TrustedProxies = splitList(*trustedProxies) },
+		"rate-limit-max-keys":         func(c *Config) { c.RateLimitMaxKeys = *rateLimitMaxKeys },
+		"rate-limit-cleanup-interval": func(c *Config) { c.RateLimitCleanupInterval = *rateLimitCleanup },
+		"redis-addr":                  func(c *Config) { c.RedisAddr = *redisAddr },
+		"redis-password":              func(c *Config) { c.RedisPassword = *redisPassword },
+
+		"login-response-format": func(c *Config) { c.LoginResponseFormat = *loginResponseFormat },
+		"cookie-sessions":       func(c *Config) { c.CookieSessions = *cookieSessions },
+
+		"jwt-algorithm":            func(c *Config) { c.JWTAlgorithm = *algorithm },
+		"jwt-key-dir":              func(c *Config) { c.JWTKeyDir = *keyDir },
+		"jwt-key-reload-interval":  func(c *Config) { c.JWTKeyReloadInterval = *keyReloadInterval },
+		"jwt-key-activation-delay": func(c *Config) { c.JWTKeyActivationDelay = *keyActivationDelay },
+		"jwt-issuer":               func(c *Config) { c.JWTIssuer = *issuer },
+		"jwt-audience":             func(c *Config) { c.JWTAudience = *audience },
+		"jwt-leeway":               func(c *Config) { c.JWTLeeway = *leeway },
+
+		"lockout-threshold":    func(c *Config) { c.LockoutThreshold = *lockoutThreshold },
+		"lockout-duration":     func(c *Config) { c.LockoutDuration = *lockoutDuration },
+		"lockout-max-duration": func(c *Config) { c.LockoutMaxDuration = *lockoutMaxDuration },
+
+		"mfa-encryption-key": func(c *Config) { c.MFAEncryptionKey = *mfaKey },
+		"mfa-issuer":         func(c *Config) { c.MFAIssuer = *mfaIssuer },
+
+		"password-min-length":    func(c *Config) { c.PasswordMinLength = *passwordMinLength },
+		"password-min-classes":   func(c *Config) { c.PasswordMinClasses = *passwordMinClasses },
+		"breached-passwords-dir": func(c *Config) { c.BreachedPasswordsDir = *breachedDir },
+
+		"password-hasher":    func(c *Config) { c.PasswordHasher = *passwordHasher },
+		"bcrypt-cost":        func(c *Config) { c.BcryptCost = *bcryptCost },
+		"argon2-memory":      func(c *Config) { c.Argon2Memory = uint32(argon2Memory.value) },
+		"argon2-iterations":  func(c *Config) { c.Argon2Iterations = uint32(argon2Iterations.value) },
+		"argon2-parallelism": func(c *Config) { c.Argon2Parallelism = uint8(argon2Parallelism.value) },
+	}
+}
+
+// Возвращает все найденные ошибки сразу, а не только первую
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.JWTAlgorithm {
+	case AlgorithmHS256:
+		if c.JWTKey == "" {
+			errs = append(errs, errors.New("jwt_key is required for HS256 (set AUTH_JWT_KEY)"))
+		} else if len(c.JWTKey) < minHMACKeyBytes {
+			errs = append(errs, fmt.Errorf("jwt_key must be at least %d bytes", minHMACKeyBytes))
+		}
+	case AlgorithmRS256, AlgorithmEdDSA:
+		if c.JWTKeyDir == "" {
+			errs = append(errs, fmt.Errorf("jwt_key_dir is required for %s", c.JWTAlgorithm))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported jwt_algorithm %q", c.JWTAlgorithm))
+	}
+
+	if c.JWTKeyReloadInterval < 0 || c.JWTKeyActivationDelay < c.JWTKeyReloadInterval {
+		errs = append(errs, errors.New("jwt_key_reload_interval must not be negative and must not exceed jwt_key_activation_delay"))
+	}
+
+	if c.JWTLeeway < 0 || c.JWTLeeway >= c.AccessTokenTTL {
+		errs = append(errs, errors.New("jwt_leeway must not be negative and must be shorter than access_token_ttl"))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %q", c.Port))
+	}
+
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("db_path is required"))
+	}
+
+	if c.RateLimit <= 0 {
+		errs = append(errs, errors.New("rate_limit must be positive"))
+	}
+
+	if c.RateWindow <= 0 {
+		errs = append(errs, errors.New("rate_window must be positive"))
+	}
+
+	defaultPolicy, routes := c.RateLimitPolicies()
+	if c.RateLimit > 0 && c.RateWindow > 0 {
+		if err := defaultPolicy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit_algorithm: %w", err))
+		}
+	}
+
+	for route, policy := range routes {
+		if err := policy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit_routes %s: %w", route, err))
+		}
+	}
+
+	if _, err := NewLogger(io.Discard, c.LogFormat, c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_format/log_level: %w", err))
+	}
+
+	if c.ReadTimeout <= 0 || c.ReadHeaderTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("read_timeout, read_header_timeout, write_timeout, idle_timeout and shutdown_timeout must be positive"))
+	}
+
+	if c.LogMaxSize < 0 || c.LogMaxAge < 0 || c.LogMaxBackups < 0 {
+		errs = append(errs, errors.New("log_max_size, log_max_age and log_max_backups must not be negative"))
+	}
+
+	if c.AccessLogBuffer <= 0 {
+		errs = append(errs, errors.New("access_log_buffer must be positive"))
+	}
+
+	if c.AuditQueueBuffer <= 0 {
+		errs = append(errs, errors.New("audit_queue_buffer must be positive"))
+	}
+
+	if c.AuditKey != "" && len(c.AuditKey) < minHMACKeyBytes {
+		errs = append(errs, fmt.Errorf("audit_key must be at least %d bytes", minHMACKeyBytes))
+	}
+
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+
+	if c.RateLimitMaxKeys < 0 {
+		errs = append(errs, errors.New("rate_limit_max_keys must not be negative"))
+	}
+
+	if c.RateLimitCleanupInterval <= 0 {
+		errs = append(errs, errors.New("rate_limit_cleanup_interval must be positive"))
+	}
+
+	switch c.RateLimitStore {
+	case RateLimitStoreMemory, RateLimitStoreSQLite:
+	case RateLimitStoreRedis:
+		if c.RedisAddr == "" {
+			errs = append(errs, errors.New("redis_addr is required for rate_limit_store redis"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported rate_limit_store %q", c.RateLimitStore))
+	}
+
+	if c.AccessTokenTTL <= 0 {
+		errs = append(errs, errors.New("access_token_ttl must be positive"))
+	}
+
+	if c.RefreshTokenTTL <= c.AccessTokenTTL {
+		errs = append(errs, errors.New("refresh_token_ttl must be longer than access_token_ttl"))
+	}
+
+	switch c.LoginResponseFormat {
+	case LoginResponseJSON, LoginResponseRaw:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported login_response_format %q", c.LoginResponseFormat))
+	}
+
+	if c.LockoutThreshold < 0 {
+		errs = append(errs, errors.New("lockout_threshold must not be negative"))
+	} else if c.LockoutThreshold > 0 {
+		if c.LockoutDuration <= 0 {
+			errs = append(errs, errors.New("lockout_duration must be positive"))
+		}
+
+		if c.LockoutMaxDuration < c.LockoutDuration {
+			errs = append(errs, errors.New("lockout_max_duration must not be shorter than lockout_duration"))
+		}
+	}
+
+	if c.PasswordMinLength < 1 || c.PasswordMinLength > maxPasswordBytes {
+		errs = append(errs, fmt.Errorf("password_min_length must be between 1 and %d", maxPasswordBytes))
+	}
+
+	if c.PasswordMinClasses < 0 || c.PasswordMinClasses > 4 {
+		errs = append(errs, errors.New("password_min_classes must be between 0 and 4"))
+	}
+
+	if c.BreachedPasswordsDir != "" {
+		if info, err := os.Stat(c.BreachedPasswordsDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("breached_passwords_dir %q is not a directory", c.BreachedPasswordsDir))
+		}
+	}
+
+	switch c.PasswordHasher {
+	case HasherArgon2id, HasherBcrypt:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported password_hasher %q", c.PasswordHasher))
+	}
+
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+
+	if c.Argon2Memory < 8*uint32(c.Argon2Parallelism) || c.Argon2Iterations < 1 || c.Argon2Parallelism < 1 {
+		errs = append(errs, errors.New("argon2 parameters must be positive and argon2_memory at least 8 KiB per thread"))
+	}
+
+	if c.Argon2Memory > maxArgon2Memory || c.Argon2Iterations > maxArgon2Iterations || c.Argon2Parallelism > maxArgon2Parallelism {
+		errs = append(errs, fmt.Errorf("argon2_memory must not exceed %d KiB, argon2_iterations %d and argon2_parallelism %d",
+			maxArgon2Memory, maxArgon2Iterations, maxArgon2Parallelism))
+	}
+
+	if c.MFAEncryptionKey != "" {
+		if _, err := NewSecretCipher(c.MFAEncryptionKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) LockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Threshold:    c.LockoutThreshold,
+		BaseDuration: c.LockoutDuration,
+		MaxDuration:  c.LockoutMaxDuration,
+	}
+}
+
+func (c *Config) PasswordHasherRegistry() (*HasherRegistry, error) {
+	params := DefaultArgon2Params()
+	params.Memory = c.Argon2Memory
+	params.Iterations = c.Argon2Iterations
+	params.Parallelism = c.Argon2Parallelism
+
+	return NewHasherRegistry(c.PasswordHasher, &BcryptHasher{Cost: c.BcryptCost}, &Argon2idHasher{Params: params})
+}
+
+func (c *Config) SaverOptions() SaverOptions {
+	return SaverOptions{
+		MaxSize:    int64(c.LogMaxSize) << 20,
+		Daily:      c.LogRotateDaily,
+		Compress:   c.LogCompress,
+		MaxAge:     c.LogMaxAge,
+		MaxBackups: c.LogMaxBackups,
+	}
+}
+
+func (c *Config) Addr() string {
+	return ":" + c.Port
+}
+
+// Политика по умолчанию и политики маршрутов; маршрут без algorithm
+// использует rate_limit_algorithm
+func (c *Config) RateLimitPolicies() (RateLimitPolicy, map[string]RateLimitPolicy) {
+	defaultPolicy := RateLimitPolicy{Algorithm: c.RateLimitAlgorithm, Limit: c.RateLimit, Period: c.RateWindow}
+
+	routes := make(map[string]RateLimitPolicy, len(c.RateLimitRoutes))
+	for route, policy := range c.RateLimitRoutes {
+		if policy.Algorithm == "" {
+			policy.Algorithm = c.RateLimitAlgorithm
+		}
+		routes[route] = policy
+	}
+
+	return defaultPolicy, routes
+}
+
+// Флаг беззнакового числа заданной разрядности: flag.Uint молча
+// обрезал бы значение при приведении к uint32 или uint8
+type uintFlag struct {
+	value   uint64
+	bitSize int
+}
+
+func (f *uintFlag) String() string {
+	return strconv.FormatUint(f.value, 10)
+}
+
+func (f *uintFlag) Set(value string) error {
+	parsed, err := strconv.ParseUint(value, 10, f.bitSize)
+	if err != nil {
+		return err
+	}
+	f.value = parsed
+	return nil
+}
+
+// "a, b,,c" -> [a b c]
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envFrom(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfig_Layers(t *testing.T) {
+	yamlPath := writeConfigFile(t, "config.yaml", `
+port: "9000"
+db_path: /tmp/from-file.db
+rate_limit: 10
+rate_window: 30s
+rate_limit_routes:
+  /login:
+    limit: 20
+    period: 1m
+    burst: 5
+`)
+	jsonPath := writeConfigFile(t, "config.json", `{"port": "9100", "rate_window": "2m"}`)
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		assert func(*testing.T, Config)
+	}{
+		{
+			name: "Defaults",
+			assert: func(t *testing.T, c Config) {
+				assert.Equal(t, DefaultConfig(), c)
+			},
+		},
+		{
+			name: "YAML file overrides defaults",
+			args: []string{"-config", yamlPath},
+			assert: func(t *testing.T, c Config) {
+				assert.Equal(t, "9000", c.Port)
+				assert.Equal(t, "/tmp/from-file.db", c.DBPath)
+				assert.Equal(t, 10, c.RateLimit)
+				assert.Equal(t, 30*time.Second, c.RateWindow)
+				assert.Equal(t, DefaultConfig().AccessTokenTTL, c.AccessTokenTTL)
+
+				_, routes := c.RateLimitPolicies()
+				assert.Equal(t, RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 20, Period: time.Minute, Burst: 5}, routes["/login"])
+				assert.Equal(t, AlgorithmGCRA, routes["/register"].Algorithm)
+			},
+		},
+		{
+			name: "JSON file from env",
+			env:  map[string]string{"AUTH_CONFIG": jsonPath},
+			assert: func(t *testing.T, c Config) {
+				assert.Equal(t, "9100", c.Port)
+				assert.Equal(t, 2*time.Minute, c.RateWindow)
+			},
+		},
+		{
+			name: "Env overrides file",
+			args: []string{"-config", yamlPath},
+			env:  map[string]string{"AUTH_PORT": "9200", "AUTH_RATE_LIMIT": "20"},
+			assert: func(t *testing.T, c Config) {
+				assert.Equal(t, "9200", c.Port)
+				assert.Equal(t, 20, c.RateLimit)
+				assert.Equal(t, "/tmp/from-file.db", c.DBPath)
+			},
+		},
+		{
+			name: "Flags override env",
+			args: []string{"-config", yamlPath, "-port", "9300", "-rate-window", "5s"},
+			env:  map[string]string{"AUTH_PORT": "9200", "AUTH_RATE_WINDOW": "1h"},
+			assert: func(t *testing.T, c Config) {
+				assert.Equal(t, "9300", c.Port)
+				assert.Equal(t, 5*time.Second, c.RateWindow)
+				assert.Equal(t, 10, c.RateLimit)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, err := loadConfig(tt.args, envFrom(tt.env))
+			require.NoError(t, err)
+			tt.assert(t, config)
+		})
+	}
+}
+
+func TestLoadConfig_ValidationErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		expectedError []string
+	}{
+		{
+			name:          "Missing HMAC secret",
+			args:          []string{"-jwt-algorithm", "HS256"},
+			expectedError: []string{"jwt_key is required"},
+		},
+		{
+			name:          "Short HMAC secret",
+			env:           map[string]string{"AUTH_JWT_ALGORITHM": "HS256", "AUTH_JWT_KEY": "short"},
+			expectedError: []string{"at least 32 bytes"},
+		},
+		{
+			name:          "Every violation is reported",
+			args:          []string{"-port", "abc", "-rate-limit", "-1", "-jwt-algorithm", "none"},
+			expectedError: []string{"invalid port", "rate_limit must be positive", "unsupported jwt_algorithm"},
+		},
+		{
+			name:          "Malformed env value",
+			env:           map[string]string{"AUTH_RATE_WINDOW": "soon"},
+			expectedError: []string{"AUTH_RATE_WINDOW"},
+		},
+		{
+			name:          "Leeway longer than access token",
+			args:          []string{"-jwt-leeway", "1h", "-access-token-ttl", "15m"},
+			expectedError: []string{"jwt_leeway"},
+		},
+		{
+			name:          "Unknown login response format",
+			env:           map[string]string{"AUTH_LOGIN_RESPONSE_FORMAT": "xml"},
+			expectedError: []string{"login_response_format"},
+		},
+		{
+			name:          "Redis store without address",
+			args:          []string{"-rate-limit-store", "redis"},
+			expectedError: []string{"redis_addr is required"},
+		},
+		{
+			name:          "Unknown rate limit algorithm",
+			env:           map[string]string{"AUTH_RATE_LIMIT_ALGORITHM": "leaky_bucket"},
+			expectedError: []string{"rate_limit_algorithm"},
+		},
+		{
+			name:          "Invalid trusted proxy",
+			env:           map[string]string{"AUTH_TRUSTED_PROXIES": "10.0.0.0/8, proxy.local"},
+			expectedError: []string{"trusted_proxies"},
+		},
+		{
+			name:          "Negative argon2 memory in env",
+			env:           map[string]string{"AUTH_ARGON2_MEMORY": "-1"},
+			expectedError: []string{"AUTH_ARGON2_MEMORY"},
+		},
+		{
+			name:          "Argon2 parallelism overflows uint8",
+			env:           map[string]string{"AUTH_ARGON2_PARALLELISM": "256"},
+			expectedError: []string{"AUTH_ARGON2_PARALLELISM"},
+		},
+		{
+			name:          "Argon2 memory flag overflows uint32",
+			args:          []string{"-argon2-memory", "4294967296"},
+			expectedError: []string{"argon2-memory"},
+		},
+		{
+			name:          "Argon2 parameters above limits",
+			args:          []string{"-argon2-memory", "8388608", "-argon2-iterations", "1000"},
+			expectedError: []string{"argon2_memory must not exceed"},
+		},
+		{
+			name:          "Zero server timeout",
+			args:          []string{"-read-header-timeout", "0s"},
+			expectedError: []string{"read_header_timeout"},
+		},
+		{
+			name:          "Missing config file",
+			args:          []string{"-config", "/nonexistent/config.yaml"},
+			expectedError: []string{"reading config file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadConfig(tt.args, envFrom(tt.env))
+			require.Error(t, err)
+
+			for _, expected := range tt.expectedError {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Коды ошибок API. Клиенты должны опираться на код, а не на текст сообщения.
+// Список с описанием — в README
+type ErrorCode string
+
+const (
+	ErrCodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	ErrCodeInvalidInput       ErrorCode = "invalid_input"
+	ErrCodeInvalidCredentials ErrorCode = "invalid_credentials"
+	ErrCodeUserExists         ErrorCode = "user_exists"
+	ErrCodePasswordPolicy     ErrorCode = "password_policy"
+	ErrCodeMissingToken       ErrorCode = "missing_token"
+	ErrCodeInvalidToken       ErrorCode = "invalid_token"
+	ErrCodeTokenRevoked       ErrorCode = "token_revoked"
+	ErrCodeTokenExpired       ErrorCode = "token_expired"
+	ErrCodeForbidden          ErrorCode = "forbidden"
+	ErrCodeCSRFFailed         ErrorCode = "csrf_failed"
+	ErrCodeAccountLocked      ErrorCode = "account_locked"
+	ErrCodeInvalidMFACode     ErrorCode = "invalid_mfa_code"
+	ErrCodeMFAAlreadyEnabled  ErrorCode = "mfa_already_enabled"
+	ErrCodeMFANotEnrolled     ErrorCode = "mfa_not_enrolled"
+	ErrCodeMFANotConfigured   ErrorCode = "mfa_not_configured"
+	ErrCodeNotFound           ErrorCode = "not_found"
+	ErrCodeRateLimited        ErrorCode = "rate_limited"
+	ErrCodeInternal           ErrorCode = "internal_error"
+	ErrCodeShuttingDown       ErrorCode = "shutting_down"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+type APIError struct {
+	Status    int          `json:"-"`
+	Code      ErrorCode    `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func NewAPIError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) WithFields(fields ...FieldError) *APIError {
+	e.Fields = append(e.Fields, fields...)
+	return e
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	writeAPIError(w, r, NewAPIError(status, code, message))
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, apiErr *APIError) {
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = requestIDFromContext(r.Context())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr})
+}
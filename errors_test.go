@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeErrorResponse(t *testing.T, rr *httptest.ResponseRecorder) *APIError {
+	t.Helper()
+
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.NotNil(t, response.Error)
+	return response.Error
+}
+
+func TestErrorEnvelope_TableDriven(t *testing.T) {
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		request      *http.Request
+		expectedCode int
+		expectedErr  ErrorCode
+	}{
+		{
+			name:         "login wrong method",
+			handler:      (&LoginHandler{}).loginHandler,
+			request:      httptest.NewRequest(http.MethodGet, "/login", nil),
+			expectedCode: http.StatusMethodNotAllowed,
+			expectedErr:  ErrCodeMethodNotAllowed,
+		},
+		{
+			name:         "register invalid input",
+			handler:      (&RegisterHandler{}).registerHandler,
+			request:      createTestRequest(http.MethodPost, "/register", map[string]interface{}{"username": ""}),
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  ErrCodeInvalidInput,
+		},
+		{
+			name:         "middleware missing token",
+			handler:      middelwareHandler(secretHandler, NewHMACKeyManager([]byte("test-secret-key-that-is-long-enough")), nil),
+			request:      httptest.NewRequest(http.MethodGet, "/secret", nil),
+			expectedCode: http.StatusUnauthorized,
+			expectedErr:  ErrCodeMissingToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			RequestIDMiddleware(tt.handler).ServeHTTP(rr, tt.request)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+
+			apiErr := decodeErrorResponse(t, rr)
+			assert.Equal(t, tt.expectedErr, apiErr.Code)
+			assert.NotEmpty(t, apiErr.Message)
+			assert.Equal(t, rr.Header().Get(requestIDHeader), apiErr.RequestID)
+		})
+	}
+}
+
+func TestRateLimitMiddleware_ErrorEnvelope(t *testing.T) {
+	limiter := NewRateLimiter(1, time.Minute)
+	handler := RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {})
+
+	executeHandler(handler, httptest.NewRequest(http.MethodPost, "/login", nil))
+	rr := executeHandler(handler, httptest.NewRequest(http.MethodPost, "/login", nil))
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, ErrCodeRateLimited, decodeErrorResponse(t, rr).Code)
+}
+
+func TestRequestIDMiddleware_PropagatesClientID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "client-request-42")
+
+	var seen string
+	rr := httptest.NewRecorder()
+	RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+	})).ServeHTTP(rr, req)
+
+	assert.Equal(t, "client-request-42", seen)
+	assert.Equal(t, "client-request-42", rr.Header().Get(requestIDHeader))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockUserRepository struct {
+	mock.Mock
+}
+
+func (mock *MockUserRepository) CreateUser(ctx context.Context, username, password string) error {
+	args := mock.Called(username, password)
+	return args.Error(0)
+}
+
+func (mock *MockUserRepository) UpdatePassword(ctx context.Context, username, password string) error {
+	args := mock.Called(username, password)
+	return args.Error(0)
+}
+
+func (mock *MockUserRepository) GetUserByUsername(ctx context.Context, name string) (string, error) {
+	args := mock.Called(name)
+	return args.String(0), args.Error(1)
+}
+
+func (mock *MockUserRepository) GetUserProfile(ctx context.Context, name string) (UserProfile, error) {
+	args := mock.Called(name)
+	return args.Get(0).(UserProfile), args.Error(1)
+}
+
+type MockPasswordHasher struct {
+	mock.Mock
+}
+
+func (mock *MockPasswordHasher) GenerateFromPassword(password []byte) ([]byte, error) {
+	args := mock.Called(password)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (mock *MockPasswordHasher) CompareHashAndPassword(stored []byte, other []byte) error {
+	args := mock.Called(stored, other)
+	return args.Error(0)
+}
+
+func (mock *MockPasswordHasher) NeedsRehash(stored []byte) bool {
+	args := mock.Called(stored)
+	return args.Bool(0)
+}
+
+type MockRefreshTokenRepository struct {
+	mock.Mock
+}
+
+func (mock *MockRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	args := mock.Called(token.Username, token.FamilyID)
+	return args.Error(0)
+}
+
+func (mock *MockRefreshTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	args := mock.Called(tokenHash)
+	return args.Get(0).(RefreshToken), args.Error(1)
+}
+
+func (mock *MockRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, tokenHash string) (bool, error) {
+	args := mock.Called(tokenHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mock *MockRefreshTokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	args := mock.Called(familyID)
+	return args.Error(0)
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+
+	db, err := initDB(context.Background(), &config)
+	if err != nil {
+		t.Fatalf("DB initialize error: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func createTestRequest(method, url string, body interface{}) *http.Request {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+
+	req := httptest.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func executeHandler(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func jtiFromToken(t *testing.T, tokenString, jwtKey string) string {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwtKey), nil
+	})
+	if err != nil {
+		t.Fatalf("Token parsing error: %v", err)
+	}
+
+	jti, _ := token.Claims.(jwt.MapClaims)["jti"].(string)
+	return jti
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+
+	// Время создания хранится в заголовке PEM: mtime меняется
+	// при cp, touch или восстановлении из бэкапа
+	pemCreatedAtHeader = "Created-At"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   interface{}
+	Public    interface{}
+	CreatedAt time.Time
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// Хранит набор ключей для проверки и выбирает из него ключ подписи.
+// При ротации старые ключи остаются в наборе, пока выданные ими токены
+// не истекут и ключ не будет удалён вручную
+type KeyManager struct {
+	mu        sync.RWMutex
+	algorithm string
+	keyDir    string
+	keys      map[string]*SigningKey
+	stop      chan struct{}
+	done      chan struct{}
+
+	// Если заданы, попадают в каждый выданный токен и проверяются в Parse
+	Issuer   string
+	Audience string
+	// Допустимое расхождение часов при проверке exp, nbf и iat
+	Leeway time.Duration
+	// Новый ключ сначала только публикуется в JWKS и принимается при
+	// проверке, а подписывать начинает через ActivationDelay после
+	// создания. К этому времени его должны загрузить все экземпляры,
+	// иначе они отвергали бы токены, подписанные им
+	ActivationDelay time.Duration
+
+	Logger *slog.Logger
+}
+
+func NewHMACKeyManager(secret []byte) *KeyManager {
+	key := &SigningKey{
+		ID:        "hs256",
+		Algorithm: AlgorithmHS256,
+		Private:   secret,
+		Public:    secret,
+		CreatedAt: time.Now(),
+	}
+
+	return &KeyManager{
+		algorithm: AlgorithmHS256,
+		keys:      map[string]*SigningKey{key.ID: key},
+	}
+}
+
+// Загружает все PEM ключи из keyDir. Если ключей нет — генерирует новый
+func NewKeyManager(algorithm, keyDir string) (*KeyManager, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return nil, err
+	}
+
+	km := &KeyManager{
+		algorithm: algorithm,
+		keyDir:    keyDir,
+	}
+
+	keys, err := loadSigningKeys(keyDir, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	km.keys = keys
+
+	if len(km.keys) == 0 {
+		if _, err := km.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return km, nil
+}
+
+func loadSigningKeys(keyDir, algorithm string) (map[string]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(keyDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*SigningKey)
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", path, err)
+		}
+
+		// Ключи другого алгоритма могут остаться после смены настроек,
+		// проверять ими токены небезопасно
+		if key.Algorithm != algorithm {
+			continue
+		}
+
+		keys[key.ID] = key
+	}
+
+	return keys, nil
+}
+
+// Перечитывает keyDir: подхватывает ключи, созданные rotate-key, и
+// забывает удалённые retire-key. При ошибке набор остаётся прежним
+func (km *KeyManager) Reload() error {
+	if km.keyDir == "" {
+		return nil
+	}
+
+	keys, err := loadSigningKeys(km.keyDir, km.algorithm)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no %s keys in %s", km.algorithm, km.keyDir)
+	}
+
+	km.mu.Lock()
+	km.keys = keys
+	km.mu.Unlock()
+
+	return nil
+}
+
+// Периодически перечитывает каталог ключей
+func (km *KeyManager) Start(interval time.Duration) {
+	if km.keyDir == "" || interval <= 0 {
+		return
+	}
+
+	km.stop = make(chan struct{})
+	km.done = make(chan struct{})
+
+	go func() {
+		defer close(km.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := km.Reload(); err != nil {
+					loggerOrDiscard(km.Logger).Error("signing keys reload error", "error", err)
+				}
+			case <-km.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (km *KeyManager) Stop() {
+	if km.stop == nil {
+		return
+	}
+
+	close(km.stop)
+	<-km.done
+	km.stop = nil
+}
+
+// Ключ подписи — самый новый из созданных не меньше ActivationDelay
+// назад. Если таких нет (первый ключ только что создан), берётся самый
+// старый: он опубликован дольше остальных. Вызывается под km.mu
+func (km *KeyManager) signingKey(now time.Time) *SigningKey {
+	var oldest, active *SigningKey
+	for _, key := range km.keys {
+		if oldest == nil || oldest.newerThan(key) {
+			oldest = key
+		}
+		if !key.CreatedAt.Add(km.ActivationDelay).After(now) && (active == nil || key.newerThan(active)) {
+			active = key
+		}
+	}
+
+	if active == nil {
+		return oldest
+	}
+	return active
+}
+
+// При равном времени создания порядок задаёт kid, чтобы все экземпляры
+// выбрали один и тот же ключ
+func (k *SigningKey) newerThan(other *SigningKey) bool {
+	if k.CreatedAt.Equal(other.CreatedAt) {
+		return k.ID > other.ID
+	}
+	return k.CreatedAt.After(other.CreatedAt)
+}
+
+// Текущий ключ подписи
+func (km *KeyManager) Current() *SigningKey {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	return km.signingKey(time.Now())
+}
+
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := keyCreatedAt(path, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(private, createdAt)
+}
+
+// Ключи, созданные до появления заголовка, датируются по mtime
+func keyCreatedAt(path string, block *pem.Block) (time.Time, error) {
+	if value, ok := block.Headers[pemCreatedAtHeader]; ok {
+		createdAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s header: %w", pemCreatedAtHeader, err)
+		}
+		return createdAt, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func newSigningKey(private interface{}, createdAt time.Time) (*SigningKey, error) {
+	var algorithm string
+	var public crypto.PublicKey
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		algorithm = AlgorithmRS256
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		algorithm = AlgorithmEdDSA
+		public = k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	id, err := keyID(public)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:        id,
+		Algorithm: algorithm,
+		Private:   private,
+		Public:    public,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// kid считается от публичного ключа, поэтому не меняется между перезапусками
+func keyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+// Генерирует новый ключ и сохраняет его на диск. Подписывать он начнёт
+// через ActivationDelay, предыдущие ключи остаются доступными для проверки
+func (km *KeyManager) Rotate() (string, error) {
+	if km.algorithm == AlgorithmHS256 {
+		return "", errors.New("HMAC keys cannot be rotated")
+	}
+
+	var private interface{}
+	var err error
+
+	if km.algorithm == AlgorithmRS256 {
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	} else {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	key, err := newSigningKey(private, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(km.keyDir, key.ID+".pem")
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{pemCreatedAtHeader: key.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		Bytes:   der,
+	})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+
+	km.mu.Lock()
+	km.keys[key.ID] = key
+	km.mu.Unlock()
+
+	return key.ID, nil
+}
+
+// Убирает ключ из набора проверки. Текущий ключ подписи удалить нельзя
+func (km *KeyManager) Retire(kid string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	key, ok := km.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	if key == km.signingKey(time.Now()) {
+		return errors.New("cannot retire current signing key")
+	}
+
+	delete(km.keys, kid)
+
+	if km.keyDir != "" {
+		return os.Remove(filepath.Join(km.keyDir, kid+".pem"))
+	}
+
+	return nil
+}
+
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	key := km.Current()
+
+	if mapClaims, ok := claims.(jwt.MapClaims); ok {
+		if _, set := mapClaims["iss"]; !set && km.Issuer != "" {
+			mapClaims["iss"] = km.Issuer
+		}
+		if _, set := mapClaims["aud"]; !set && km.Audience != "" {
+			mapClaims["aud"] = km.Audience
+		}
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+// Секрет для токенов, которые проверяет только сам сервис (промежуточный
+// токен 2FA). Выводится из закрытого ключа и нигде не публикуется, так что
+// сервисы, проверяющие токены по JWKS или общему HMAC секрету, их не примут
+func (k *SigningKey) internalSecret() ([]byte, error) {
+	material, ok := k.Private.([]byte)
+	if !ok {
+		der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+		if err != nil {
+			return nil, err
+		}
+		material = der
+	}
+
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte("internal-token"))
+	return mac.Sum(nil), nil
+}
+
+// Подписывает внутренний токен (HS256 на выведенном секрете текущего ключа)
+func (km *KeyManager) SignInternal(claims jwt.MapClaims) (string, error) {
+	key := km.Current()
+
+	secret, err := key.internalSecret()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(secret)
+}
+
+// Проверяет токен, выданный SignInternal
+func (km *KeyManager) ParseInternal(tokenString string) (jwt.MapClaims, error) {
+	keyfunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		km.mu.RLock()
+		key, ok := km.keys[kid]
+		km.mu.RUnlock()
+
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return key.internalSecret()
+	}
+
+	token, err := jwt.Parse(tokenString, keyfunc,
+		jwt.WithValidMethods([]string{AlgorithmHS256}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(km.Leeway))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return claims, nil
+}
+
+// Keyfunc для jwt.Parse: ищет ключ по kid и проверяет, что алгоритм токена
+// совпадает с алгоритмом ключа, иначе возможна подмена RS256 на HS256
+func (km *KeyManager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	key := km.signingKey(time.Now())
+	if kid, ok := t.Header["kid"].(string); ok {
+		key, ok = km.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	if t.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.Public, nil
+}
+
+func (km *KeyManager) Algorithm() string {
+	return km.algorithm
+}
+
+// Проверяет подпись и стандартные claims. exp и username обязательны,
+// iss и aud — если они заданы в настройках
+func (km *KeyManager) Parse(tokenString string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{km.Algorithm()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(km.Leeway),
+	}
+
+	if km.Issuer != "" {
+		options = append(options, jwt.WithIssuer(km.Issuer))
+	}
+
+	if km.Audience != "" {
+		options = append(options, jwt.WithAudience(km.Audience))
+	}
+
+	token, err := jwt.Parse(tokenString, km.Keyfunc, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	if username, _ := claims["username"].(string); username == "" {
+		return nil, fmt.Errorf("%w: username", jwt.ErrTokenRequiredClaimMissing)
+	}
+
+	return claims, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Публикуются только асимметричные ключи: HMAC секрет раскрывать нельзя
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range km.keys {
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Algorithm,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Algorithm,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
+
+func (km *KeyManager) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use get")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(km.JWKS())
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"username": "testuser",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestKeyManager_SignAndVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		kty       string
+	}{
+		{name: "RS256", algorithm: AlgorithmRS256, kty: "RSA"},
+		{name: "EdDSA", algorithm: AlgorithmEdDSA, kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeyManager(tt.algorithm, t.TempDir())
+			require.NoError(t, err)
+
+			tokenString, err := keys.Sign(testClaims())
+			require.NoError(t, err)
+
+			token, err := jwt.Parse(tokenString, keys.Keyfunc)
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, tt.algorithm, token.Method.Alg())
+			assert.NotEmpty(t, token.Header["kid"])
+
+			jwks := keys.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, tt.kty, jwks.Keys[0].Kty)
+			assert.Equal(t, token.Header["kid"], jwks.Keys[0].Kid)
+		})
+	}
+}
+
+func TestKeyManager_Rotation(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := NewKeyManager(AlgorithmRS256, dir)
+	require.NoError(t, err)
+
+	oldToken, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+
+	oldKid := keys.JWKS().Keys[0].Kid
+
+	newKid, err := keys.Rotate()
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKid, newKid)
+
+	// Токен, подписанный старым ключом, всё ещё проверяется
+	_, err = jwt.Parse(oldToken, keys.Keyfunc)
+	assert.NoError(t, err)
+	assert.Len(t, keys.JWKS().Keys, 2)
+
+	newToken, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(newToken, keys.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, newKid, parsed.Header["kid"])
+
+	// После перезапуска загружаются оба ключа, новый остаётся текущим
+	reloaded, err := NewKeyManager(AlgorithmRS256, dir)
+	require.NoError(t, err)
+	assert.Len(t, reloaded.JWKS().Keys, 2)
+
+	_, err = jwt.Parse(oldToken, reloaded.Keyfunc)
+	assert.NoError(t, err)
+
+	assert.Error(t, keys.Retire(newKid))
+	require.NoError(t, keys.Retire(oldKid))
+
+	_, err = jwt.Parse(oldToken, keys.Keyfunc)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeyManager_CreatedAtIgnoresMtime(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := NewKeyManager(AlgorithmEdDSA, dir)
+	require.NoError(t, err)
+	oldKid := keys.JWKS().Keys[0].Kid
+
+	newKid, err := keys.Rotate()
+	require.NoError(t, err)
+
+	// Старый ключ "свежее" по mtime, например после cp или touch
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, oldKid+".pem"), future, future))
+
+	reloaded, err := NewKeyManager(AlgorithmEdDSA, dir)
+	require.NoError(t, err)
+
+	token, err := reloaded.Sign(testClaims())
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(token, reloaded.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, newKid, parsed.Header["kid"])
+}
+
+// Два экземпляра с общим каталогом ключей: ключ, созданный ротацией,
+// сначала публикуется обоими и только потом начинает подписывать
+func TestKeyManager_ReloadPublishesBeforeSigning(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := NewKeyManager(AlgorithmEdDSA, dir)
+	require.NoError(t, err)
+	second, err := NewKeyManager(AlgorithmEdDSA, dir)
+	require.NoError(t, err)
+	oldKid := first.Current().ID
+
+	for _, keys := range []*KeyManager{first, second} {
+		keys.ActivationDelay = time.Hour
+	}
+
+	newKid, err := first.Rotate()
+	require.NoError(t, err)
+	require.NoError(t, second.Reload())
+
+	// Новый ключ уже принимается обоими, но ещё не подписывает
+	for _, keys := range []*KeyManager{first, second} {
+		assert.Len(t, keys.JWKS().Keys, 2)
+		assert.Equal(t, oldKid, keys.Current().ID)
+	}
+
+	for _, keys := range []*KeyManager{first, second} {
+		keys.ActivationDelay = 0
+	}
+	assert.Equal(t, newKid, first.Current().ID)
+
+	token, err := first.Sign(testClaims())
+	require.NoError(t, err)
+	_, err = second.Parse(token)
+	require.NoError(t, err)
+
+	// retire-key в другом процессе: после перечитывания ключа нет
+	require.NoError(t, first.Retire(oldKid))
+	require.NoError(t, second.Reload())
+	assert.Len(t, second.JWKS().Keys, 1)
+	assert.Equal(t, newKid, second.Current().ID)
+}
+
+func TestKeyManager_ReloadKeepsKeysOnError(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := NewKeyManager(AlgorithmEdDSA, dir)
+	require.NoError(t, err)
+	kid := keys.Current().ID
+
+	require.NoError(t, os.Remove(filepath.Join(dir, kid+".pem")))
+	assert.Error(t, keys.Reload())
+	assert.Equal(t, kid, keys.Current().ID)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0600))
+	assert.Error(t, keys.Reload())
+	assert.Equal(t, kid, keys.Current().ID)
+}
+
+func TestKeyCommands(t *testing.T) {
+	config := DefaultConfig()
+	config.JWTAlgorithm = AlgorithmEdDSA
+	config.JWTKeyDir = t.TempDir()
+
+	// Пустой каталог: первый ключ создаётся при загрузке, второй — ротацией
+	require.NoError(t, runCommand(context.Background(), &config, []string{"rotate-key"}))
+
+	keys, err := initKeys(&config)
+	require.NoError(t, err)
+	require.Len(t, keys.JWKS().Keys, 2)
+
+	current, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+	parsed, err := jwt.Parse(current, keys.Keyfunc)
+	require.NoError(t, err)
+	currentKid := parsed.Header["kid"].(string)
+
+	var oldKid string
+	for _, key := range keys.JWKS().Keys {
+		if key.Kid != currentKid {
+			oldKid = key.Kid
+		}
+	}
+
+	assert.Error(t, runCommand(context.Background(), &config, []string{"retire-key", currentKid}))
+	assert.Error(t, runCommand(context.Background(), &config, []string{"retire-key", "unknown"}))
+	require.NoError(t, runCommand(context.Background(), &config, []string{"retire-key", oldKid}))
+
+	keys, err = initKeys(&config)
+	require.NoError(t, err)
+	assert.Len(t, keys.JWKS().Keys, 1)
+}
+
+func TestKeyManager_RejectsAlgorithmConfusion(t *testing.T) {
+	keys, err := NewKeyManager(AlgorithmRS256, t.TempDir())
+	require.NoError(t, err)
+
+	kid := keys.JWKS().Keys[0].Kid
+	public, err := keys.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": kid}})
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	require.NoError(t, err)
+
+	// Подписываем HS256 публичным ключом как секретом
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = kid
+	forgedString, err := forged.SignedString(der)
+	require.NoError(t, err)
+
+	_, err = jwt.Parse(forgedString, keys.Keyfunc)
+	assert.Error(t, err)
+}
+
+func TestKeyManager_JWKSHandler(t *testing.T) {
+	keys, err := NewKeyManager(AlgorithmEdDSA, t.TempDir())
+	require.NoError(t, err)
+
+	rr := executeHandler(keys.jwksHandler, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var set JWKSet
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, "Ed25519", set.Keys[0].Crv)
+
+	// HMAC секрет не публикуется
+	assert.Empty(t, NewHMACKeyManager([]byte("secret")).JWKS().Keys)
+}
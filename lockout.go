@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type LockState struct {
+	FailedAttempts int
+	LockedUntil    time.Time
+}
+
+type IAccountLockRepository interface {
+	GetLockState(context.Context, string) (LockState, error)
+	IncrementFailedAttempts(context.Context, string) (int, error)
+	LockUntil(context.Context, string, time.Time) error
+	ResetFailedAttempts(context.Context, string) error
+}
+
+// Порог неудачных попыток и длительность блокировки. Каждая следующая
+// неудачная попытка после порога удваивает блокировку до MaxDuration
+type LockoutPolicy struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+func (p LockoutPolicy) LockDuration(failedAttempts int) time.Duration {
+	if p.Threshold <= 0 || failedAttempts < p.Threshold {
+		return 0
+	}
+
+	duration := p.BaseDuration
+	for i := p.Threshold; i < failedAttempts && duration < p.MaxDuration; i++ {
+		duration *= 2
+	}
+
+	if duration > p.MaxDuration {
+		duration = p.MaxDuration
+	}
+
+	return duration
+}
+
+type AccountLockout struct {
+	Repo   IAccountLockRepository
+	Policy LockoutPolicy
+}
+
+// Возвращает оставшееся время блокировки, 0 если аккаунт не заблокирован
+func (a *AccountLockout) Remaining(ctx context.Context, username string) (time.Duration, error) {
+	state, err := a.Repo.GetLockState(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Until(state.LockedUntil), nil
+}
+
+func (a *AccountLockout) RecordFailure(ctx context.Context, username, ip string) error {
+	attempts, err := a.Repo.IncrementFailedAttempts(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	duration := a.Policy.LockDuration(attempts)
+	if duration == 0 {
+		return nil
+	}
+
+	if err := a.Repo.LockUntil(ctx, username, time.Now().Add(duration)); err != nil {
+		return err
+	}
+
+	recordAudit(ctx, AuditEvent{
+		Type:     AuditAccountLocked,
+		Username: username,
+		IP:       ip,
+		Details:  map[string]string{"failed_attempts": strconv.Itoa(attempts), "duration": duration.String()},
+	})
+	return nil
+}
+
+func (a *AccountLockout) RecordSuccess(ctx context.Context, username string) error {
+	state, err := a.Repo.GetLockState(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if state.FailedAttempts == 0 {
+		return nil
+	}
+
+	return a.Repo.ResetFailedAttempts(ctx, username)
+}
+
+func (a *AccountLockout) Unlock(ctx context.Context, username, by string) error {
+	if err := a.Repo.ResetFailedAttempts(ctx, username); err != nil {
+		return err
+	}
+
+	recordAudit(ctx, AuditEvent{Type: AuditAccountUnlocked, Username: username, Details: map[string]string{"by": by}})
+	return nil
+}
+
+type UnlockRequest struct {
+	Username string `json:"username"`
+}
+
+type UnlockHandler struct {
+	Lockout *AccountLockout
+}
+
+func (h *UnlockHandler) unlockHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
+		return
+	}
+
+	var request UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Username == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
+		return
+	}
+
+	admin := usernameFromContext(r)
+
+	err := h.Lockout.Unlock(ctx, request.Username, admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, ErrCodeNotFound, "User not found")
+		return
+	}
+
+	if err != nil {
+		requestLogger(r, "lockout").Error("unlock error", "username", request.Username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Unlock error")
+		return
+	}
+
+	w.Write([]byte("User unlocked"))
+}
+
+func writeLocked(w http.ResponseWriter, r *http.Request, remaining time.Duration) {
+	seconds := int(remaining.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, http.StatusLocked, ErrCodeAccountLocked, "Account temporarily locked")
+}
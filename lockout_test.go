@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLockoutPolicy_LockDuration(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 3, BaseDuration: time.Minute, MaxDuration: 10 * time.Minute}
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 0, expected: 0},
+		{attempts: 2, expected: 0},
+		{attempts: 3, expected: time.Minute},
+		{attempts: 4, expected: 2 * time.Minute},
+		{attempts: 6, expected: 8 * time.Minute},
+		{attempts: 7, expected: 10 * time.Minute},
+		{attempts: 100, expected: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, policy.LockDuration(tt.attempts), "attempts=%d", tt.attempts)
+	}
+
+	assert.Equal(t, time.Duration(0), LockoutPolicy{}.LockDuration(10))
+}
+
+func TestLoginHandler_AccountLockout(t *testing.T) {
+	ctx := context.Background()
+	repository := &SQLRepository{bd: newTestDB(t)}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, repository.CreateUser(ctx, "victim", string(hashed)))
+
+	lockout := &AccountLockout{
+		Repo:   repository,
+		Policy: LockoutPolicy{Threshold: 3, BaseDuration: time.Minute, MaxDuration: time.Hour},
+	}
+
+	handler := LoginHandler{
+		Repo:    repository,
+		Hasher:  &BcryptHasher{},
+		Keys:    NewHMACKeyManager([]byte("test-secret-key")),
+		Lockout: lockout,
+	}
+
+	login := func(password string) int {
+		req := createTestRequest(http.MethodPost, "/login", map[string]interface{}{
+			"username": "victim",
+			"password": password,
+		})
+		return executeHandler(handler.loginHandler, req).Code
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("wrongpassword"))
+	}
+
+	// Даже верный пароль не принимается, пока аккаунт заблокирован
+	req := createTestRequest(http.MethodPost, "/login", map[string]interface{}{
+		"username": "victim",
+		"password": "correctpassword",
+	})
+	rr := executeHandler(handler.loginHandler, req)
+	assert.Equal(t, http.StatusLocked, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	unlock := UnlockHandler{Lockout: lockout}
+	req = createTestRequest(http.MethodPost, "/admin/unlock", map[string]interface{}{"username": "victim"})
+	req = req.WithContext(WithPrincipal(req.Context(), Principal{Username: "admin"}))
+	assert.Equal(t, http.StatusOK, executeHandler(unlock.unlockHandler, req).Code)
+
+	assert.Equal(t, http.StatusOK, login("correctpassword"))
+
+	state, err := repository.GetLockState(ctx, "victim")
+	require.NoError(t, err)
+	assert.Equal(t, 0, state.FailedAttempts)
+
+	req = createTestRequest(http.MethodPost, "/admin/unlock", map[string]interface{}{"username": "ghost"})
+	assert.Equal(t, http.StatusNotFound, executeHandler(unlock.unlockHandler, req).Code)
+}
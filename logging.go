@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+
+	loggerContextKey = contextKey("logger")
+)
+
+// Логгер по умолчанию для компонентов, которым его не передали
+// (в первую очередь тесты): ничего не пишет
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unsupported log level %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported log format %q", format)
+}
+
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return discardLogger
+}
+
+// Логгер запроса с атрибутом компонента
+func requestLogger(r *http.Request, component string) *slog.Logger {
+	return loggerFromContext(r.Context()).With("component", component)
+}
+
+// Кладёт в контекст логгер, к записям которого добавлены ID запроса,
+// адрес клиента, метод и путь. Должен стоять после RequestIDMiddleware
+// и ClientIPMiddleware
+func RequestLoggerMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogger := logger.With(
+			"request_id", requestIDFromContext(r.Context()),
+			"client_ip", getClientIP(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+
+		next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), requestLogger)))
+	})
+}
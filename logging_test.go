@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Строки JSON лога в виде map
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		level         string
+		expectedError bool
+		expected      string
+	}{
+		{name: "JSON", format: "json", level: "info", expected: `"msg":"hello"`},
+		{name: "Text", format: "text", level: "INFO", expected: "msg=hello"},
+		{name: "Level filters", format: "json", level: "warn", expected: ""},
+		{name: "Unknown format", format: "xml", level: "info", expectedError: true},
+		{name: "Unknown level", format: "json", level: "loud", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := NewLogger(&buf, tt.format, tt.level)
+
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			logger.Info("hello")
+			if tt.expected == "" {
+				assert.Empty(t, buf.String())
+			} else {
+				assert.Contains(t, buf.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, LogFormatJSON, "debug")
+	require.NoError(t, err)
+
+	handler := RequestIDMiddleware(RequestLoggerMiddleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r, "test").Info("handled")
+		recordAudit(r.Context(), AuditEvent{Type: "something_happened", Username: "alice"})
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set(requestIDHeader, "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := decodeLogLines(t, &buf)
+	require.Len(t, lines, 2)
+
+	for _, line := range lines {
+		assert.Equal(t, "req-42", line["request_id"])
+		assert.Equal(t, "192.0.2.1", line["client_ip"])
+		assert.Equal(t, "/login", line["path"])
+	}
+
+	assert.Equal(t, "test", lines[0]["component"])
+	assert.Equal(t, "audit", lines[1]["msg"])
+	assert.Equal(t, "something_happened", lines[1]["event"])
+	assert.Equal(t, "alice", lines[1]["username"])
+}
+
+func TestLoggerFromContext_Discards(t *testing.T) {
+	// Без логгера в контексте запись не должна падать
+	loggerFromContext(context.Background()).Info("nowhere")
+	recordAudit(context.Background(), AuditEvent{Type: "event"})
+}
+
+func TestMiddleware_LogsWithRequestContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, LogFormatJSON, "info")
+	require.NoError(t, err)
+
+	keys := NewHMACKeyManager([]byte("test-secret-key-that-is-long-enough"))
+	handler := RequestLoggerMiddleware(logger, middelwareHandler(secretHandler, keys, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", "Bearer not.a.token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := decodeLogLines(t, &buf)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "invalid token", lines[0]["msg"])
+	assert.Equal(t, "WARN", lines[0]["level"])
+	assert.Equal(t, "auth", lines[0]["component"])
+	assert.Equal(t, "/secret", lines[0]["path"])
+
+	// Отказ дублируется записью аудита
+	assert.Equal(t, "audit", lines[1]["msg"])
+	assert.Equal(t, "token_rejected", lines[1]["event"])
+	assert.Equal(t, "/secret", lines[1]["path"])
+}
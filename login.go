@@ -1,61 +1,256 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAccessTTL  = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
 )
 
 type LoginHandler struct {
-	Repo   IRepository
-	Hasher IPasswordHasher
-	JwtKey []byte
+	Repo        IRepository
+	Hasher      IPasswordHasher
+	Keys        *KeyManager
+	Roles       IRoleRepository
+	Lockout     *AccountLockout
+	MFA         IMFARepository
+	RefreshRepo IRefreshTokenRepository
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
+
+	// Старый формат ответа: токен сырой строкой в теле, refresh токен в заголовке
+	LegacyResponse bool
+	// Разрешает клиентам получать сессию в HttpOnly cookie (X-Session-Mode: cookie)
+	CookieSessions bool
+}
+
+// Ответ в духе OAuth2 (RFC 6749, раздел 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 }
 
 func (l *LoginHandler) loginHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Use post", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
 		return
 	}
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
 		return
 	}
 
 	if user.Username == "" || user.Password == "" {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
 		return
 	}
 
 	storedPassword, err := l.Repo.GetUserByUsername(ctx, user.Username)
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		auditLoginFailure(ctx, user.Username, "unknown_user")
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
+	// Пока аккаунт заблокирован, пароль даже не проверяется
+	if l.Lockout != nil {
+		remaining, err := l.Lockout.Remaining(ctx, user.Username)
+		if err != nil {
+			requestLogger(r, "login").Error("lock state error", "username", user.Username, "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+			return
+		}
+
+		if remaining > 0 {
+			auditLoginFailure(ctx, user.Username, "account_locked")
+			writeLocked(w, r, remaining)
+			return
+		}
+	}
+
 	err = l.Hasher.CompareHashAndPassword([]byte(storedPassword), []byte(user.Password))
 	if err != nil {
-		http.Error(w, "Invalid creditans", http.StatusUnauthorized)
+		if l.Lockout != nil {
+			if err := l.Lockout.RecordFailure(ctx, user.Username, getClientIP(r)); err != nil {
+				requestLogger(r, "login").Error("failed login recording error", "username", user.Username, "error", err)
+			}
+		}
+
+		auditLoginFailure(ctx, user.Username, "invalid_password")
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": user.Username,
-		"exp":      time.Now().Add(time.Hour).Unix(),
-	})
+	l.rehashIfNeeded(ctx, user.Username, storedPassword, user.Password)
+
+	// При включённой 2FA счётчик неудач сбрасывается только после второго шага,
+	// иначе верный пароль позволял бы перебирать коды бесконечно
+	if l.MFA != nil {
+		state, err := l.MFA.GetMFAState(ctx, user.Username)
+		if err != nil {
+			requestLogger(r, "login").Error("MFA state error", "username", user.Username, "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+			return
+		}
+
+		if state.Enabled {
+			l.writeMFAChallenge(w, r, user.Username)
+			return
+		}
+	}
+
+	l.writeTokens(w, r, user.Username)
+}
+
+func auditLoginFailure(ctx context.Context, username, reason string) {
+	recordAudit(ctx, AuditEvent{Type: AuditLoginFailure, Username: username, Details: map[string]string{"reason": reason}})
+}
+
+// Пароль известен только в момент входа, поэтому хэши старого алгоритма
+// или с устаревшими параметрами пересчитываются здесь. Ошибка не мешает входу
+func (l *LoginHandler) rehashIfNeeded(ctx context.Context, username, storedPassword, password string) {
+	if !l.Hasher.NeedsRehash([]byte(storedPassword)) {
+		return
+	}
+
+	hashed, err := l.Hasher.GenerateFromPassword([]byte(password))
+	if err != nil {
+		loggerFromContext(ctx).Error("password rehash error", "component", "login", "username", username, "error", err)
+		return
+	}
+
+	if err := l.Repo.UpdatePassword(ctx, username, string(hashed)); err != nil {
+		loggerFromContext(ctx).Error("password hash update error", "component", "login", "username", username, "error", err)
+		return
+	}
+
+	loggerFromContext(ctx).Info("password hash upgraded", "component", "login", "username", username)
+}
+
+func (l *LoginHandler) writeTokens(w http.ResponseWriter, r *http.Request, username string) {
+	ctx := r.Context()
+	recordAccessLogUser(ctx, username)
 
-	tokenstring, err := token.SignedString(l.JwtKey)
+	if l.Lockout != nil {
+		if err := l.Lockout.RecordSuccess(ctx, username); err != nil {
+			requestLogger(r, "login").Error("failed attempts reset error", "username", username, "error", err)
+		}
+	}
+
+	tokenstring, permissions, err := l.generateAccessToken(ctx, username)
 
 	if err != nil {
-		http.Error(w, "Token generating error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+		return
+	}
+
+	var refreshToken string
+	if l.RefreshRepo != nil {
+		refreshToken, err = l.issueRefreshToken(ctx, username, newTokenFamilyID())
+		if err != nil {
+			requestLogger(r, "login").Error("refresh token issuing error", "username", username, "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+			return
+		}
+	}
+
+	recordAudit(ctx, AuditEvent{Type: AuditLoginSuccess, Username: username})
+
+	if l.wantsCookieSession(r) {
+		l.writeSessionCookies(w, r, tokenstring, refreshToken, permissions)
 		return
 	}
 
-	w.Write([]byte(tokenstring))
+	if l.wantsLegacyResponse(r) {
+		if refreshToken != "" {
+			w.Header().Set("X-Refresh-Token", refreshToken)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(tokenstring))
+		return
+	}
+
+	l.writeTokenResponse(w, tokenstring, refreshToken, permissions)
+}
+
+// Сырой токен отдаётся, если так настроен сервер или клиент явно просит text/plain
+func (l *LoginHandler) wantsLegacyResponse(r *http.Request) bool {
+	return l.LegacyResponse || r.Header.Get("Accept") == "text/plain"
+}
+
+func (l *LoginHandler) writeTokenResponse(w http.ResponseWriter, accessToken, refreshToken string, permissions []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(l.accessTTL() / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(permissions, " "),
+	})
+}
+
+func (l *LoginHandler) accessTTL() time.Duration {
+	if l.AccessTTL <= 0 {
+		return defaultAccessTTL
+	}
+	return l.AccessTTL
+}
+
+// Возвращает подписанный токен и права, попавшие в него
+func (l *LoginHandler) generateAccessToken(ctx context.Context, username string) (string, []string, error) {
+	ttl := l.accessTTL()
+
+	claims := jwt.MapClaims{
+		"username": username,
+		"jti":      uuid.NewString(),
+		"iat":      time.Now().Unix(),
+		"exp":      time.Now().Add(ttl).Unix(),
+	}
+
+	if l.Repo != nil {
+		profile, err := l.Repo.GetUserProfile(ctx, username)
+		if err != nil {
+			return "", nil, err
+		}
+
+		claims["sub"] = strconv.FormatInt(profile.ID, 10)
+	}
+
+	var permissions []string
+	if l.Roles != nil {
+		roles, err := l.Roles.GetUserRoles(ctx, username)
+		if err != nil {
+			return "", nil, err
+		}
+
+		permissions, err = l.Roles.GetUserPermissions(ctx, username)
+		if err != nil {
+			return "", nil, err
+		}
+
+		claims["roles"] = roles
+		claims["permissions"] = permissions
+	}
+
+	token, err := l.Keys.Sign(claims)
+	return token, permissions, err
 }
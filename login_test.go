@@ -2,11 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -28,8 +31,28 @@ func TestLoginHandler_TableDriven(t *testing.T) {
 			setupMocks: func(mur *MockUserRepository, mph *MockPasswordHasher) {
 				hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
 				mur.On("GetUserByUsername", "validuser").Return(string(hashedPassword), nil)
+				mur.On("GetUserProfile", "validuser").Return(UserProfile{ID: 1, Username: "validuser"}, nil)
 
 				mph.On("CompareHashAndPassword", []byte(hashedPassword), []byte("correctpassword")).Return(nil)
+				mph.On("NeedsRehash", []byte(hashedPassword)).Return(false)
+			},
+			expectedCode: http.StatusOK,
+			checkToken:   true,
+		},
+		{
+			name: "Successful login upgrades stale hash",
+			requestBody: map[string]interface{}{
+				"username": "validuser",
+				"password": "correctpassword",
+			},
+			setupMocks: func(mur *MockUserRepository, mph *MockPasswordHasher) {
+				mur.On("GetUserByUsername", "validuser").Return("old_hash", nil)
+				mur.On("UpdatePassword", "validuser", "new_hash").Return(nil)
+				mur.On("GetUserProfile", "validuser").Return(UserProfile{ID: 1, Username: "validuser"}, nil)
+
+				mph.On("CompareHashAndPassword", []byte("old_hash"), []byte("correctpassword")).Return(nil)
+				mph.On("NeedsRehash", []byte("old_hash")).Return(true)
+				mph.On("GenerateFromPassword", []byte("correctpassword")).Return([]byte("new_hash"), nil)
 			},
 			expectedCode: http.StatusOK,
 			checkToken:   true,
@@ -48,7 +71,7 @@ func TestLoginHandler_TableDriven(t *testing.T) {
 					Return(bcrypt.ErrMismatchedHashAndPassword)
 			},
 			expectedCode: http.StatusUnauthorized,
-			expectedBody: "Invalid creditans",
+			expectedBody: "Invalid credentials",
 		},
 		{
 			name: "User not found",
@@ -107,7 +130,7 @@ func TestLoginHandler_TableDriven(t *testing.T) {
 			handler := LoginHandler{
 				Repo:   mockRepository,
 				Hasher: mockHasher,
-				JwtKey: []byte("test-secret-key"),
+				Keys:   NewHMACKeyManager([]byte("test-secret-key")),
 			}
 
 			req := createTestRequest(http.MethodPost, "/login", tt.requestBody)
@@ -120,10 +143,15 @@ func TestLoginHandler_TableDriven(t *testing.T) {
 			}
 
 			if tt.checkToken {
-				tokenString := rr.Body.String()
-				assert.NotEmpty(t, tokenString)
+				assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+				assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
 
-				token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+				var response TokenResponse
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+				assert.Equal(t, "Bearer", response.TokenType)
+				assert.Equal(t, int(defaultAccessTTL/time.Second), response.ExpiresIn)
+
+				token, err := jwt.Parse(response.AccessToken, func(token *jwt.Token) (interface{}, error) {
 					return []byte("test-secret-key"), nil
 				})
 
@@ -132,6 +160,7 @@ func TestLoginHandler_TableDriven(t *testing.T) {
 
 				if claims, ok := token.Claims.(jwt.MapClaims); ok {
 					assert.Equal(t, "validuser", claims["username"])
+					assert.Equal(t, "1", claims["sub"])
 					assert.NotEmpty(t, claims["exp"])
 				}
 			}
@@ -141,3 +170,48 @@ func TestLoginHandler_TableDriven(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginHandler_LegacyResponse(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+
+	tests := []struct {
+		name   string
+		legacy bool
+		accept string
+	}{
+		{name: "configured legacy mode", legacy: true},
+		{name: "client asks for text/plain", accept: "text/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepository := &MockUserRepository{}
+			mockRepository.On("GetUserByUsername", "validuser").Return(string(hashedPassword), nil)
+			mockRepository.On("GetUserProfile", "validuser").Return(UserProfile{ID: 1, Username: "validuser"}, nil)
+
+			handler := LoginHandler{
+				Repo:           mockRepository,
+				Hasher:         &BcryptHasher{},
+				Keys:           NewHMACKeyManager([]byte("test-secret-key")),
+				LegacyResponse: tt.legacy,
+			}
+
+			req := createTestRequest(http.MethodPost, "/login", map[string]interface{}{
+				"username": "validuser",
+				"password": "password123",
+			})
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := executeHandler(handler.loginHandler, req)
+
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			token, err := jwt.Parse(rr.Body.String(), func(token *jwt.Token) (interface{}, error) {
+				return []byte("test-secret-key"), nil
+			})
+			assert.NoError(t, err)
+			assert.True(t, token.Valid)
+		})
+	}
+}
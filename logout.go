@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type LogoutHandler struct {
+	Revocations IRevocationStore
+	RefreshRepo IRefreshTokenRepository
+}
+
+// Отзывает access токен вызывающего. Если в теле передан refresh_token,
+// отзывается и всё его семейство, чтобы сессию нельзя было продлить
+func (h *LogoutHandler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
+		return
+	}
+
+	claims, ok := claimsFromContext(ctx)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	if err := h.Revocations.Revoke(ctx, jti, exp.Time); err != nil {
+		requestLogger(r, "logout").Error("token revoking error", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Logout error")
+		return
+	}
+
+	var request RefreshRequest
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+		request.RefreshToken = cookie.Value
+	}
+	if r.ContentLength != 0 {
+		json.NewDecoder(r.Body).Decode(&request)
+	}
+
+	if request.RefreshToken != "" && h.RefreshRepo != nil {
+		username, _ := claims["username"].(string)
+		h.revokeRefreshFamily(r, username, request.RefreshToken)
+	}
+
+	clearSessionCookies(w)
+	w.Write([]byte("Logged out successfully"))
+}
+
+func (h *LogoutHandler) revokeRefreshFamily(r *http.Request, username, refreshToken string) {
+	ctx := r.Context()
+
+	stored, err := h.RefreshRepo.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil || stored.Username != username {
+		return
+	}
+
+	if err := h.RefreshRepo.RevokeTokenFamily(ctx, stored.FamilyID); err != nil {
+		requestLogger(r, "logout").Error("revoking token family error", "family_id", stored.FamilyID, "error", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogout_RevokesToken(t *testing.T) {
+	jwtKey := "test-secret-key"
+	db := newTestDB(t)
+
+	revocations, err := NewSQLRevocationStore(context.Background(), db)
+	require.NoError(t, err)
+
+	keys := NewHMACKeyManager([]byte(jwtKey))
+	loginHandler := LoginHandler{Keys: keys}
+	logoutHandler := LogoutHandler{Revocations: revocations}
+
+	secret := middelwareHandler(secretHandler, keys, revocations)
+	logout := middelwareHandler(logoutHandler.logoutHandler, keys, revocations)
+
+	token, _, err := loginHandler.generateAccessToken(context.Background(), "validuser")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", token)
+	assert.Equal(t, http.StatusOK, executeHandler(secret, req).Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("Authorization", token)
+	rr := executeHandler(logout, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Logged out successfully")
+
+	req = httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", token)
+	rr = executeHandler(secret, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Token revoked")
+
+	// Отзыв переживает перезапуск: кэш заполняется из базы
+	reloaded, err := NewSQLRevocationStore(context.Background(), db)
+	require.NoError(t, err)
+
+	revoked, err := reloaded.IsRevoked(context.Background(), jtiFromToken(t, token, jwtKey))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestRevocationStore_SharedDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	// Два экземпляра сервиса с общей базой
+	first, err := NewSQLRevocationStore(ctx, db)
+	require.NoError(t, err)
+	second, err := NewSQLRevocationStore(ctx, db)
+	require.NoError(t, err)
+
+	revoked, err := second.IsRevoked(ctx, "shared")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, first.Revoke(ctx, "shared", time.Now().Add(time.Hour)))
+
+	revoked, err = second.IsRevoked(ctx, "shared")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Отзыв, записанный в базу напрямую, тоже учитывается
+	_, err = db.Exec("INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", "direct", time.Now().Add(time.Hour).Unix())
+	require.NoError(t, err)
+
+	revoked, err = first.IsRevoked(ctx, "direct")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Истёкшая, но ещё не удалённая запись отзывом не считается
+	_, err = db.Exec("INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", "stale", time.Now().Add(-time.Minute).Unix())
+	require.NoError(t, err)
+
+	revoked, err = second.IsRevoked(ctx, "stale")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRevocationStore_Prune(t *testing.T) {
+	db := newTestDB(t)
+
+	store, err := NewSQLRevocationStore(context.Background(), db)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.Revoke(ctx, "expired", time.Now().Add(-time.Minute)))
+	require.NoError(t, store.Revoke(ctx, "active", time.Now().Add(time.Hour)))
+
+	require.NoError(t, store.Prune(ctx))
+
+	revoked, _ := store.IsRevoked(ctx, "expired")
+	assert.False(t, revoked)
+
+	revoked, _ = store.IsRevoked(ctx, "active")
+	assert.True(t, revoked)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM revoked_tokens").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestMiddelware_RejectsTokenWithoutJti(t *testing.T) {
+	jwtKey := "test-secret-key"
+
+	store, err := NewSQLRevocationStore(context.Background(), newTestDB(t))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", generateValidToken(jwtKey, "testuser"))
+
+	rr := executeHandler(middelwareHandler(secretHandler, NewHMACKeyManager([]byte(jwtKey)), store), req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
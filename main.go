@@ -1,80 +1,358 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	_ "modernc.org/sqlite"
 )
 
-func initDB(config *Config) (*sql.DB, error) {
-	var db, err = sql.Open("sqlite", config.DBPath)
+const sqliteBusyTimeoutMS = 5000
+
+func openDB(config *Config) (*sql.DB, error) {
+	return sql.Open("sqlite", sqliteDSN(config.DBPath))
+}
+
+// Без busy_timeout конкурентная запись сразу падает с SQLITE_BUSY,
+// а не ждёт освобождения блокировки
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_pragma=busy_timeout(" + strconv.Itoa(sqliteBusyTimeoutMS) + ")"
+}
+
+// Открывает базу и доводит схему до последней версии
+func initDB(ctx context.Context, config *Config) (*sql.DB, error) {
+	var db, err = openDB(config)
 
 	if err != nil {
 		return nil, err
 	}
 
-	createTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		password TEXT NOT NULL)`
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return db, err
+	}
 
-	_, err = db.Exec(createTable)
+	applied, err := migrator.Up(ctx)
+	if applied > 0 {
+		loggerFromContext(ctx).Info("applied database migrations", "count", applied)
+	}
 
 	return db, err
 }
 
-func getKey() string {
-	return "secretKey"
+func initKeys(config *Config) (*KeyManager, error) {
+	var keys *KeyManager
+	var err error
+
+	if config.JWTAlgorithm == AlgorithmHS256 {
+		keys = NewHMACKeyManager([]byte(config.JWTKey))
+	} else if keys, err = NewKeyManager(config.JWTAlgorithm, config.JWTKeyDir); err != nil {
+		return nil, err
+	}
+
+	keys.Issuer = config.JWTIssuer
+	keys.Audience = config.JWTAudience
+	keys.Leeway = config.JWTLeeway
+	keys.ActivationDelay = config.JWTKeyActivationDelay
+
+	return keys, nil
 }
 
-func startAuth(db *sql.DB, limiter *RateLimiter) {
+func initRateLimiter(config *Config, db *sql.DB, logger *slog.Logger) *RateLimiter {
+	var store RateLimitStore
+
+	switch config.RateLimitStore {
+	case RateLimitStoreSQLite:
+		store = NewSQLRateLimitStore(db)
+	case RateLimitStoreRedis:
+		store = NewRedisRateLimitStore(config.RedisAddr, config.RedisPassword)
+	default:
+		store = NewMemoryRateLimitStore(config.RateLimitMaxKeys)
+	}
+
+	defaultPolicy, routes := config.RateLimitPolicies()
+	limiter := NewRateLimiterWithStore(store, defaultPolicy, routes)
+	limiter.Logger = logger.With("component", "rate_limiter")
+	return limiter
+}
+
+func startAuth(db *sql.DB, limiter *RateLimiter, revocations *SQLRevocationStore, audit IAuditLog, keys *KeyManager, config *Config, logger *slog.Logger) error {
 	var userRepository = SQLRepository{
 		bd: db,
 	}
 
-	var hasher = BcryptHasher{}
-	var key = getKey()
+	var refreshRepository = SQLRefreshTokenRepository{
+		bd: db,
+	}
+
+	hasher, err := config.PasswordHasherRegistry()
+	if err != nil {
+		return err
+	}
+
+	var lockout = AccountLockout{
+		Repo:   &userRepository,
+		Policy: config.LockoutPolicy(),
+	}
 
 	var loginHandler = LoginHandler{
+		Repo:        &userRepository,
+		Hasher:      hasher,
+		Keys:        keys,
+		Roles:       &userRepository,
+		Lockout:     &lockout,
+		MFA:         &userRepository,
+		RefreshRepo: &refreshRepository,
+		AccessTTL:   config.AccessTokenTTL,
+		RefreshTTL:  config.RefreshTokenTTL,
+
+		LegacyResponse: config.LoginResponseFormat == LoginResponseRaw,
+		CookieSessions: config.CookieSessions,
+	}
+
+	var mfaHandler = MFAHandler{
 		Repo:   &userRepository,
-		Hasher: &hasher,
-		JwtKey: []byte(key),
+		Issuer: config.MFAIssuer,
+		Login:  &loginHandler,
+
+		Revocations: revocations,
+	}
+
+	// Без ключа 2FA нельзя включить, но уже включённая продолжает требоваться
+	if config.MFAEncryptionKey != "" {
+		mfaHandler.Cipher, _ = NewSecretCipher(config.MFAEncryptionKey)
 	}
 
 	var registerHandler = RegisterHandler{
 		UserRepo: &userRepository,
-		Hasher:   &hasher,
+		Hasher:   hasher,
+		Policy:   NewPasswordPolicy(config, logger),
+	}
+
+	var logoutHandler = LogoutHandler{
+		Revocations: revocations,
+		RefreshRepo: &refreshRepository,
+	}
+
+	var meHandler = MeHandler{
+		Repo:  &userRepository,
+		Roles: &userRepository,
+	}
+
+	var unlockHandler = UnlockHandler{
+		Lockout: &lockout,
+	}
+
+	var auditHandler = AuditHandler{
+		Audit: audit,
 	}
 
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
 	http.HandleFunc("/login", RateLimitMiddleware(limiter, loginHandler.loginHandler))
+	http.HandleFunc("/login/mfa", RateLimitMiddleware(limiter, mfaHandler.loginMFAHandler))
+	http.HandleFunc("/mfa/enroll", middelwareHandler(mfaHandler.enrollHandler, keys, revocations))
+	http.HandleFunc("/mfa/confirm", middelwareHandler(mfaHandler.confirmHandler, keys, revocations))
+	http.HandleFunc("/token/refresh", RateLimitMiddleware(limiter, loginHandler.refreshHandler))
 	http.HandleFunc("/register", RateLimitMiddleware(limiter, registerHandler.registerHandler))
-	http.HandleFunc("/secret", middelwareHandler(secretHandler, key))
+	http.HandleFunc("/.well-known/jwks.json", keys.jwksHandler)
+	http.HandleFunc("/me", middelwareHandler(meHandler.meHandler, keys, revocations))
+	http.HandleFunc("/logout", middelwareHandler(logoutHandler.logoutHandler, keys, revocations))
+	http.HandleFunc("/admin/rate-limits", middelwareHandler(RequirePermission(limiter.statsHandler, PermissionUsersManage), keys, revocations))
+	http.HandleFunc("/admin/audit", middelwareHandler(RequirePermission(auditHandler.auditHandler, PermissionAuditRead), keys, revocations))
+	http.HandleFunc("/admin/unlock", middelwareHandler(RequirePermission(unlockHandler.unlockHandler, PermissionUsersManage), keys, revocations))
+	http.HandleFunc("/secret", middelwareHandler(RequirePermission(secretHandler, PermissionSecretRead), keys, revocations))
+
+	return nil
 }
 
 func main() {
-	config := DefaultConfig()
-	limiter := NewRateLimiter(config.RateLimit, config.RateWindow)
+	config, args, err := LoadConfig(os.Args[1:])
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config error: %v\n", err)
+		os.Exit(1)
+	}
+
+	saver := NewSaver(config.LogFile, config.SaverOptions())
+	if err := saver.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Log file error: %v\n", err)
+		os.Exit(1)
+	}
 
-	saver := NewSaver()
-	saver.Start()
+	// Config.Validate уже проверил формат и уровень
+	logger, _ := NewLogger(io.MultiWriter(os.Stdout, saver), config.LogFormat, config.LogLevel)
+	ctx := WithLogger(context.Background(), logger)
 
-	defer saver.Stop()
+	if len(args) > 0 {
+		err = runCommand(ctx, &config, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	} else {
+		err = runServer(ctx, &config, logger, saver)
+		if err != nil {
+			logger.Error("server error", "error", err)
+		}
+	}
 
-	var db, err = initDB(&config)
+	// Файл лога закрывается последним, чтобы в него попали записи
+	// об остановке остальных компонентов
+	saver.Stop()
 
 	if err != nil {
-		log.Fatal("DB Initialize error")
-		return
+		os.Exit(1)
+	}
+}
+
+// Запускает сервер и блокируется до SIGINT/SIGTERM. Компоненты
+// останавливаются в обратном порядке запуска (defer): сначала сервер
+// дожидается текущих запросов, затем лимитер, очистка отзывов, база,
+// которой они пользуются, и журнал запросов
+func runServer(ctx context.Context, config *Config, logger *slog.Logger, saver *Saver) error {
+	// Пустой путь — Saver ничего не пишет, а очередь просто разгружается
+	accessSaver := NewSaver(config.AccessLogPath, config.SaverOptions())
+	if err := accessSaver.Start(); err != nil {
+		return fmt.Errorf("access log file: %w", err)
+	}
+
+	defer accessSaver.Stop()
+
+	accessLog := NewAccessLog(accessSaver, config.AccessLogBuffer)
+	accessLog.Logger = logger
+	defer accessLog.Close()
+
+	db, err := initDB(ctx, config)
+	if db != nil {
+		defer db.Close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("DB initialize: %w", err)
+	}
+
+	revocations, err := NewSQLRevocationStore(ctx, db)
+	if err != nil {
+		return fmt.Errorf("revocation store initialize: %w", err)
+	}
+
+	revocations.Logger = logger.With("component", "revocations")
+	revocations.Start(config.AccessTokenTTL)
+	defer revocations.Stop()
+
+	limiter := initRateLimiter(config, db, logger)
+	limiter.Start(config.RateLimitCleanupInterval)
+	defer limiter.Close()
+
+	keys, err := initKeys(config)
+	if err != nil {
+		return fmt.Errorf("signing keys initialize: %w", err)
+	}
+
+	keys.Logger = logger.With("component", "keys")
+	keys.Start(config.JWTKeyReloadInterval)
+	defer keys.Stop()
+
+	// Внешний logrotate переименовывает файлы и шлёт SIGHUP. По нему же
+	// сразу перечитываются ключи после rotate-key или retire-key
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// signal.Stop не закрывает канал, горутину останавливает hupDone
+	hupDone := make(chan struct{})
+	defer close(hupDone)
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+			case <-hupDone:
+				return
+			}
+
+			for _, s := range []*Saver{saver, accessSaver} {
+				if err := s.Reopen(); err != nil {
+					logger.Error("log file reopen error", "path", s.path, "error", err)
+				}
+			}
+
+			if err := keys.Reload(); err != nil {
+				logger.Error("signing keys reload error", "error", err)
+			}
+		}
+	}()
+
+	audit := NewSQLAuditLog(db, config.AuditKey)
+	if config.AuditKey == "" {
+		logger.Warn("audit_key is not set, the audit chain detects corruption but not tampering")
+	}
+
+	// Закрывается до базы и после того, как сервер дождался запросов
+	auditQueue := NewAuditQueue(audit, config.AuditQueueBuffer)
+	auditQueue.Logger = logger
+	defer auditQueue.Close()
+
+	if err := startAuth(db, limiter, revocations, audit, keys, config, logger); err != nil {
+		return fmt.Errorf("auth initialize: %w", err)
 	}
 
-	startAuth(db, limiter)
+	// Config.Validate уже проверил список
+	proxies, _ := ParseTrustedProxies(config.TrustedProxies)
+
+	var handler http.Handler = AuditMiddleware(audit, auditQueue, http.DefaultServeMux)
+	if config.AccessLogPath != "" {
+		handler = AccessLogMiddleware(accessLog, handler)
+	}
+	handler = RequestLoggerMiddleware(logger, handler)
+	handler = ClientIPMiddleware(proxies, handler)
+	handler = RequestIDMiddleware(handler)
+
+	var inFlight InFlightRequests
+	handler = inFlight.Middleware(handler)
+
+	listener, err := net.Listen("tcp", config.Addr())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Повторный сигнал во время ожидания запросов завершает процесс сразу
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	logger.Info("server started", "addr", "http://localhost:"+config.Port)
+	err = serveHTTP(ctx, newHTTPServer(config, handler, logger), listener, config.ShutdownTimeout, logger)
+
+	// Обработчики закрытых соединений ещё могут писать в журналы и базу
+	if !inFlight.Wait(handlerExitTimeout) {
+		logger.Warn("handlers still running after shutdown", "timeout", handlerExitTimeout.String())
+	}
+	logger.Info("server stopped")
 
-	fmt.Println("Server started on http://localhost:8888")
-	log.Fatal(http.ListenAndServe(":8888", nil))
+	return err
 }
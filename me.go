@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type MeResponse struct {
+	ID          int64    `json:"id"`
+	Username    string   `json:"username"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	MFAEnabled  bool     `json:"mfa_enabled"`
+}
+
+type MeHandler struct {
+	Repo  IRepository
+	Roles IRoleRepository
+}
+
+// Профиль читается из базы, а не из токена: роли могли измениться после входа
+func (h *MeHandler) meHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use get")
+		return
+	}
+
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Username == "" {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	profile, err := h.Repo.GetUserProfile(ctx, principal.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, ErrCodeNotFound, "User not found")
+		return
+	}
+
+	if err != nil {
+		requestLogger(r, "me").Error("profile loading error", "username", principal.Username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+		return
+	}
+
+	response := MeResponse{
+		ID:         profile.ID,
+		Username:   profile.Username,
+		MFAEnabled: profile.MFAEnabled,
+	}
+
+	if h.Roles != nil {
+		if response.Roles, err = h.Roles.GetUserRoles(ctx, profile.Username); err == nil {
+			response.Permissions, err = h.Roles.GetUserPermissions(ctx, profile.Username)
+		}
+
+		if err != nil {
+			requestLogger(r, "me").Error("roles loading error", "username", profile.Username, "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+			return
+		}
+	}
+
+	// Пустые списки отдаются как [], а не null
+	if response.Roles == nil {
+		response.Roles = []string{}
+	}
+	if response.Permissions == nil {
+		response.Permissions = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
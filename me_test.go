@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeHandler_ReturnsCurrentUser(t *testing.T) {
+	ctx := context.Background()
+	repository := &SQLRepository{bd: newTestDB(t)}
+	keys := NewHMACKeyManager([]byte("test-secret-key"))
+
+	require.NoError(t, repository.CreateUser(ctx, "alice", "hash"))
+	require.NoError(t, repository.AssignRole(ctx, "alice", RoleAdmin))
+
+	loginHandler := LoginHandler{Repo: repository, Keys: keys, Roles: repository}
+	token, _, err := loginHandler.generateAccessToken(ctx, "alice")
+	require.NoError(t, err)
+
+	var principal Principal
+	meHandler := MeHandler{Repo: repository, Roles: repository}
+	handler := middelwareHandler(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+		meHandler.meHandler(w, r)
+	}, keys, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := executeHandler(handler, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response MeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+
+	assert.Equal(t, "alice", response.Username)
+	assert.NotZero(t, response.ID)
+	assert.ElementsMatch(t, []string{RoleUser, RoleAdmin}, response.Roles)
+	assert.Contains(t, response.Permissions, PermissionUsersManage)
+	assert.False(t, response.MFAEnabled)
+
+	assert.Equal(t, response.ID, principal.UserID)
+	assert.Equal(t, "alice", principal.Username)
+	assert.True(t, principal.HasRole(RoleAdmin))
+	assert.Equal(t, jtiFromToken(t, token, "test-secret-key"), principal.TokenID)
+}
+
+func TestMeHandler_TableDriven(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		principal    *Principal
+		setupMocks   func(*MockUserRepository)
+		expectedCode int
+	}{
+		{
+			name:         "Wrong method",
+			method:       http.MethodPost,
+			setupMocks:   func(mur *MockUserRepository) {},
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:         "No principal",
+			method:       http.MethodGet,
+			setupMocks:   func(mur *MockUserRepository) {},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:      "Deleted user",
+			method:    http.MethodGet,
+			principal: &Principal{Username: "ghost"},
+			setupMocks: func(mur *MockUserRepository) {
+				mur.On("GetUserProfile", "ghost").Return(UserProfile{}, sql.ErrNoRows)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepository := &MockUserRepository{}
+			tt.setupMocks(mockRepository)
+
+			handler := MeHandler{Repo: mockRepository}
+
+			req := httptest.NewRequest(tt.method, "/me", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), *tt.principal))
+			}
+
+			rr := executeHandler(handler.meHandler, req)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+
+			mockRepository.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSecretHandler_GreetsPrincipal(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), Principal{Username: "alice"}))
+
+	rr := executeHandler(secretHandler, req)
+	assert.Contains(t, rr.Body.String(), "alice")
+}
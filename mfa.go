@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	tokenTypeMFAPending = "mfa_pending"
+	mfaPendingTTL       = 5 * time.Minute
+)
+
+var errMFANotConfigured = errors.New("mfa_encryption_key is not configured")
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type MFAConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+type MFAConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAHandler struct {
+	Repo   IMFARepository
+	Cipher *SecretCipher
+	Issuer string
+	Login  *LoginHandler
+	// Отзывает использованные промежуточные токены. Без него токен
+	// можно предъявить повторно до истечения
+	Revocations IRevocationStore
+}
+
+type mfaPendingToken struct {
+	username  string
+	jti       string
+	expiresAt time.Time
+}
+
+// Первый шаг входа для пользователей с 2FA: вместо access токена
+// выдаётся короткоживущий одноразовый токен, который меняется на настоящий
+// в /login/mfa. Он подписан внутренним ключом, поэтому как access токен
+// его не примет ни этот сервис, ни проверяющие по JWKS
+func (l *LoginHandler) writeMFAChallenge(w http.ResponseWriter, r *http.Request, username string) {
+	token, err := l.Keys.SignInternal(jwt.MapClaims{
+		"username": username,
+		"typ":      tokenTypeMFAPending,
+		"jti":      uuid.NewString(),
+		"exp":      time.Now().Add(mfaPendingTTL).Unix(),
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+func (h *MFAHandler) enrollHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
+		return
+	}
+
+	if h.Cipher == nil {
+		writeError(w, r, http.StatusServiceUnavailable, ErrCodeMFANotConfigured, "MFA is not configured")
+		return
+	}
+
+	username := usernameFromContext(r)
+
+	state, err := h.Repo.GetMFAState(ctx, username)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	if state.Enabled {
+		writeError(w, r, http.StatusConflict, ErrCodeMFAAlreadyEnabled, "MFA already enabled")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Secret generating error")
+		return
+	}
+
+	encrypted, err := h.Cipher.Encrypt(secret, username)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Secret generating error")
+		return
+	}
+
+	if err := h.Repo.SetPendingTOTPSecret(ctx, username, encrypted); err != nil {
+		requestLogger(r, "mfa").Error("TOTP secret saving error", "username", username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Secret generating error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(MFAEnrollResponse{
+		Secret:     secret,
+		OtpauthURI: totpURI(h.Issuer, username, secret),
+	})
+}
+
+// Подтверждение кодом из приложения включает 2FA и выдаёт коды восстановления.
+// Коды показываются один раз, в базе хранятся только их хэши
+func (h *MFAHandler) confirmHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
+		return
+	}
+
+	if h.Cipher == nil {
+		writeError(w, r, http.StatusServiceUnavailable, ErrCodeMFANotConfigured, "MFA is not configured")
+		return
+	}
+
+	var request MFAConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
+		return
+	}
+
+	username := usernameFromContext(r)
+
+	state, err := h.Repo.GetMFAState(ctx, username)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	if state.Enabled {
+		writeError(w, r, http.StatusConflict, ErrCodeMFAAlreadyEnabled, "MFA already enabled")
+		return
+	}
+
+	if state.EncryptedSecret == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeMFANotEnrolled, "MFA enrollment not started")
+		return
+	}
+
+	secret, err := h.Cipher.Decrypt(state.EncryptedSecret, username)
+	if err != nil {
+		requestLogger(r, "mfa").Error("TOTP secret decrypting error", "username", username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+		return
+	}
+
+	step, ok := verifyTOTP(secret, request.Code, time.Now())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidMFACode, "Invalid code")
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Recovery codes generating error")
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = h.Cipher.HashRecoveryCode(code, username)
+	}
+
+	if err := h.Repo.EnableTOTP(ctx, username, step, hashes); err != nil {
+		requestLogger(r, "mfa").Error("TOTP enabling error", "username", username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+		return
+	}
+
+	recordAudit(r.Context(), AuditEvent{Type: AuditMFAEnabled, Username: username})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(MFAConfirmResponse{RecoveryCodes: codes})
+}
+
+func (h *MFAHandler) loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
+		return
+	}
+
+	var request MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MFAToken == "" ||
+		(request.Code == "") == (request.RecoveryCode == "") {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
+		return
+	}
+
+	pending, err := h.parseMFAToken(ctx, request.MFAToken)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid token")
+		return
+	}
+
+	username := pending.username
+
+	lockout := h.Login.Lockout
+	if lockout != nil {
+		remaining, err := lockout.Remaining(ctx, username)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+			return
+		}
+
+		if remaining > 0 {
+			auditLoginFailure(ctx, username, "account_locked")
+			writeLocked(w, r, remaining)
+			return
+		}
+	}
+
+	ok, err := h.verifySecondFactor(r, username, request)
+	if err != nil {
+		requestLogger(r, "mfa").Error("MFA verification error", "username", username, "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+		return
+	}
+
+	if !ok {
+		if lockout != nil {
+			if err := lockout.RecordFailure(ctx, username, getClientIP(r)); err != nil {
+				requestLogger(r, "mfa").Error("failed login recording error", "username", username, "error", err)
+			}
+		}
+
+		auditLoginFailure(ctx, username, "invalid_mfa_code")
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidMFACode, "Invalid code")
+		return
+	}
+
+	// Промежуточный токен одноразовый
+	if h.Revocations != nil {
+		if err := h.Revocations.Revoke(ctx, pending.jti, pending.expiresAt); err != nil {
+			requestLogger(r, "mfa").Error("MFA token revoking error", "username", username, "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+			return
+		}
+	}
+
+	h.Login.writeTokens(w, r, username)
+}
+
+func (h *MFAHandler) verifySecondFactor(r *http.Request, username string, request MFALoginRequest) (bool, error) {
+	ctx := r.Context()
+
+	if request.RecoveryCode != "" {
+		used, err := h.useRecoveryCode(ctx, username, request.RecoveryCode)
+		if used {
+			recordAudit(r.Context(), AuditEvent{Type: AuditMFARecoveryCodeUsed, Username: username})
+		}
+		return used, err
+	}
+
+	state, err := h.Repo.GetMFAState(ctx, username)
+	if err != nil {
+		return false, err
+	}
+
+	if !state.Enabled {
+		return false, nil
+	}
+
+	if h.Cipher == nil {
+		return false, errMFANotConfigured
+	}
+
+	secret, err := h.Cipher.Decrypt(state.EncryptedSecret, username)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := verifyTOTP(secret, request.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Повторное предъявление уже использованного кода считается неудачей
+	return h.Repo.UseTOTPStep(ctx, username, step)
+}
+
+func (h *MFAHandler) useRecoveryCode(ctx context.Context, username, code string) (bool, error) {
+	if h.Cipher == nil {
+		return false, errMFANotConfigured
+	}
+
+	return h.Repo.UseRecoveryCode(ctx, username, h.Cipher.HashRecoveryCode(code, username))
+}
+
+func (h *MFAHandler) parseMFAToken(ctx context.Context, tokenString string) (mfaPendingToken, error) {
+	claims, err := h.Login.Keys.ParseInternal(tokenString)
+	if err != nil {
+		return mfaPendingToken{}, err
+	}
+
+	if typ, _ := claims["typ"].(string); typ != tokenTypeMFAPending {
+		return mfaPendingToken{}, errors.New("not an MFA token")
+	}
+
+	token := mfaPendingToken{}
+	token.username, _ = claims["username"].(string)
+	token.jti, _ = claims["jti"].(string)
+	if token.username == "" || token.jti == "" {
+		return mfaPendingToken{}, jwt.ErrTokenRequiredClaimMissing
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return mfaPendingToken{}, err
+	}
+	token.expiresAt = exp.Time
+
+	if h.Revocations != nil {
+		used, err := h.Revocations.IsRevoked(ctx, token.jti)
+		if err != nil {
+			return mfaPendingToken{}, err
+		}
+		if used {
+			return mfaPendingToken{}, errors.New("MFA token already used")
+		}
+	}
+
+	return token, nil
+}
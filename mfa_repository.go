@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+)
+
+type MFAState struct {
+	EncryptedSecret string
+	Enabled         bool
+	LastStep        int64
+}
+
+type IMFARepository interface {
+	GetMFAState(context.Context, string) (MFAState, error)
+	SetPendingTOTPSecret(context.Context, string, string) error
+	EnableTOTP(context.Context, string, int64, []string) error
+	UseTOTPStep(context.Context, string, int64) (bool, error)
+	UseRecoveryCode(context.Context, string, string) (bool, error)
+}
+
+func (r *SQLRepository) GetMFAState(ctx context.Context, name string) (MFAState, error) {
+	var state MFAState
+	row := r.bd.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE username = ?", name)
+	err := row.Scan(&state.EncryptedSecret, &state.Enabled, &state.LastStep)
+	return state, err
+}
+
+// Секрет сохраняется неподтверждённым, пока пользователь не введёт код из приложения
+func (r *SQLRepository) SetPendingTOTPSecret(ctx context.Context, name, encryptedSecret string) error {
+	_, err := r.bd.ExecContext(ctx,
+		"UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE username = ? AND totp_enabled = 0",
+		encryptedSecret, name)
+	return err
+}
+
+// Включает TOTP и заменяет коды восстановления в одной транзакции
+func (r *SQLRepository) EnableTOTP(ctx context.Context, name string, step int64, recoveryHashes []string) error {
+	tx, err := r.bd.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE username = ?", step, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM recovery_codes WHERE user_id = (SELECT id FROM users WHERE username = ?)", name)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash) SELECT id, ? FROM users WHERE username = ?",
+			hash, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Шаг принимается только если он новее последнего использованного,
+// поэтому один и тот же код нельзя предъявить дважды
+func (r *SQLRepository) UseTOTPStep(ctx context.Context, name string, step int64) (bool, error) {
+	result, err := r.bd.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = ? WHERE username = ? AND totp_last_step < ?", step, name, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (r *SQLRepository) UseRecoveryCode(ctx context.Context, name, codeHash string) (bool, error) {
+	result, err := r.bd.ExecContext(ctx, `
+		UPDATE recovery_codes SET used = 1
+		WHERE code_hash = ? AND used = 0 AND user_id = (SELECT id FROM users WHERE username = ?)`,
+		codeHash, name)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTP_RFC6238Vectors(t *testing.T) {
+	// Секрет "12345678901234567890" из приложения B RFC 6238
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := totpCode(secret, totpStep(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, code)
+	}
+
+	step, ok := verifyTOTP(secret, "287082", time.Unix(59+30, 0))
+	assert.True(t, ok, "previous step is accepted")
+	assert.Equal(t, int64(1), step)
+
+	_, ok = verifyTOTP(secret, "287082", time.Unix(59+90, 0))
+	assert.False(t, ok)
+}
+
+func TestSecretCipher_BindsOwner(t *testing.T) {
+	cipher, err := NewSecretCipher(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+
+	encrypted, err := cipher.Encrypt("SECRET", "alice")
+	require.NoError(t, err)
+
+	decrypted, err := cipher.Decrypt(encrypted, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET", decrypted)
+
+	_, err = cipher.Decrypt(encrypted, "bob")
+	assert.Error(t, err)
+
+	_, err = NewSecretCipher("c2hvcnQ=")
+	assert.Error(t, err)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodes)
+
+	for _, code := range codes {
+		raw, err := totpEncoding.DecodeString(strings.ToUpper(normalizeRecoveryCode(code)))
+		require.NoError(t, err)
+		assert.Len(t, raw, 10, "80 bits of entropy")
+		assert.Len(t, code, 19)
+	}
+
+	cipher, err := NewSecretCipher(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+	other, err := NewSecretCipher(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	require.NoError(t, err)
+
+	hash := cipher.HashRecoveryCode(codes[0], "alice")
+	assert.Equal(t, hash, cipher.HashRecoveryCode(" "+strings.ToUpper(codes[0])+" ", "alice"))
+	assert.Equal(t, hash, cipher.HashRecoveryCode(strings.ReplaceAll(codes[0], "-", ""), "alice"))
+	assert.NotEqual(t, hash, cipher.HashRecoveryCode(codes[0], "bob"), "salted per user")
+	assert.NotEqual(t, hash, other.HashRecoveryCode(codes[0], "alice"), "keyed by the server")
+}
+
+func TestMFA_EnrollmentAndLogin(t *testing.T) {
+	ctx := context.Background()
+	repository := &SQLRepository{bd: newTestDB(t)}
+	keys := NewHMACKeyManager([]byte("test-secret-key"))
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, repository.CreateUser(ctx, "alice", string(hashed)))
+
+	cipher, err := NewSecretCipher(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	require.NoError(t, err)
+
+	loginHandler := &LoginHandler{
+		Repo:   repository,
+		Hasher: &BcryptHasher{},
+		Keys:   keys,
+		MFA:    repository,
+		Lockout: &AccountLockout{
+			Repo:   repository,
+			Policy: LockoutPolicy{Threshold: 5, BaseDuration: time.Minute, MaxDuration: time.Hour},
+		},
+	}
+	mfaHandler := MFAHandler{Repo: repository, Cipher: cipher, Issuer: "Test", Login: loginHandler}
+
+	withUser := func(req *http.Request) *http.Request {
+		return req.WithContext(WithPrincipal(req.Context(), Principal{Username: "alice"}))
+	}
+
+	// Включение 2FA
+	rr := executeHandler(mfaHandler.enrollHandler, withUser(createTestRequest(http.MethodPost, "/mfa/enroll", nil)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var enrollment MFAEnrollResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&enrollment))
+	assert.Contains(t, enrollment.OtpauthURI, "otpauth://totp/Test:alice?")
+
+	code, err := totpCode(enrollment.Secret, totpStep(time.Now()))
+	require.NoError(t, err)
+
+	rr = executeHandler(mfaHandler.confirmHandler,
+		withUser(createTestRequest(http.MethodPost, "/mfa/confirm", map[string]interface{}{"code": code})))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var confirmation MFAConfirmResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&confirmation))
+	assert.Len(t, confirmation.RecoveryCodes, recoveryCodes)
+
+	state, err := repository.GetMFAState(ctx, "alice")
+	require.NoError(t, err)
+	assert.True(t, state.Enabled)
+	assert.NotContains(t, state.EncryptedSecret, enrollment.Secret)
+
+	// Первый шаг входа возвращает только промежуточный токен
+	passwordStep := func() string {
+		rr := executeHandler(loginHandler.loginHandler, createTestRequest(http.MethodPost, "/login",
+			map[string]interface{}{"username": "alice", "password": "password123"}))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var challenge MFAChallengeResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&challenge))
+		require.True(t, challenge.MFARequired)
+		return challenge.MFAToken
+	}
+
+	mfaToken := passwordStep()
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", mfaToken)
+	assert.Equal(t, http.StatusUnauthorized, executeHandler(middelwareHandler(secretHandler, keys, nil), req).Code)
+
+	secondStep := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		body["mfa_token"] = mfaToken
+		return executeHandler(mfaHandler.loginMFAHandler, createTestRequest(http.MethodPost, "/login/mfa", body))
+	}
+
+	nextCode, err := totpCode(enrollment.Secret, totpStep(time.Now())+1)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, secondStep(map[string]interface{}{"code": "000000"}).Code)
+
+	rr = secondStep(map[string]interface{}{"code": nextCode})
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var tokens TokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens))
+
+	req = httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, executeHandler(middelwareHandler(secretHandler, keys, nil), req).Code)
+
+	// Тот же код повторно не принимается
+	assert.Equal(t, http.StatusUnauthorized, secondStep(map[string]interface{}{"code": nextCode}).Code)
+
+	recovery := confirmation.RecoveryCodes[0]
+	assert.Equal(t, http.StatusOK, secondStep(map[string]interface{}{"recovery_code": recovery}).Code)
+	assert.Equal(t, http.StatusUnauthorized, secondStep(map[string]interface{}{"recovery_code": recovery}).Code)
+
+	// Повторное включение запрещено
+	rr = executeHandler(mfaHandler.enrollHandler, withUser(createTestRequest(http.MethodPost, "/mfa/enroll", nil)))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestMFA_PendingTokenIsNotAnAccessToken(t *testing.T) {
+	keys, err := NewKeyManager(AlgorithmRS256, t.TempDir())
+	require.NoError(t, err)
+	keys.Audience = "api"
+
+	loginHandler := &LoginHandler{Keys: keys}
+	rr := httptest.NewRecorder()
+	loginHandler.writeMFAChallenge(rr, httptest.NewRequest(http.MethodPost, "/login", nil), "alice")
+
+	var challenge MFAChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&challenge))
+
+	// Сервис, проверяющий токены по опубликованным ключам
+	_, err = keys.Parse(challenge.MFAToken)
+	assert.Error(t, err)
+
+	_, err = jwt.Parse(challenge.MFAToken, func(token *jwt.Token) (interface{}, error) {
+		return keys.Current().Public, nil
+	})
+	assert.Error(t, err)
+
+	claims, err := keys.ParseInternal(challenge.MFAToken)
+	require.NoError(t, err)
+	assert.Equal(t, tokenTypeMFAPending, claims["typ"])
+
+	// Access токен не проходит как промежуточный
+	access, _, err := loginHandler.generateAccessToken(context.Background(), "alice")
+	require.NoError(t, err)
+	_, err = keys.ParseInternal(access)
+	assert.Error(t, err)
+}
+
+func TestMFA_PendingTokenIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repository := &SQLRepository{bd: db}
+	require.NoError(t, repository.CreateUser(ctx, "alice", "hash"))
+
+	cipher, err := NewSecretCipher(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+
+	codes := []string{"aaaa-bbbb-cccc-dddd", "eeee-ffff-gggg-hhhh"}
+	require.NoError(t, repository.EnableTOTP(ctx, "alice", 1, []string{
+		cipher.HashRecoveryCode(codes[0], "alice"),
+		cipher.HashRecoveryCode(codes[1], "alice"),
+	}))
+
+	revocations, err := NewSQLRevocationStore(ctx, db)
+	require.NoError(t, err)
+
+	loginHandler := &LoginHandler{Keys: NewHMACKeyManager([]byte("test-secret-key-that-is-long-enough"))}
+	mfaHandler := MFAHandler{Repo: repository, Cipher: cipher, Login: loginHandler, Revocations: revocations}
+
+	rr := httptest.NewRecorder()
+	loginHandler.writeMFAChallenge(rr, httptest.NewRequest(http.MethodPost, "/login", nil), "alice")
+
+	var challenge MFAChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&challenge))
+
+	secondStep := func(code string) int {
+		body := map[string]interface{}{"mfa_token": challenge.MFAToken, "recovery_code": code}
+		return executeHandler(mfaHandler.loginMFAHandler, createTestRequest(http.MethodPost, "/login/mfa", body)).Code
+	}
+
+	assert.Equal(t, http.StatusOK, secondStep(codes[0]))
+	assert.Equal(t, http.StatusUnauthorized, secondStep(codes[1]), "token already exchanged")
+}
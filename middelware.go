@@ -1,45 +1,177 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"log"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+const bearerRealm = "WebAutorize"
+
+// Коды ошибок из RFC 6750, раздел 3.1
+const (
+	bearerErrInvalidRequest    = "invalid_request"
+	bearerErrInvalidToken      = "invalid_token"
+	bearerErrInsufficientScope = "insufficient_scope"
+)
+
+var (
+	errMalformedAuthorization = errors.New("malformed Authorization header")
+	errUnsupportedAuthScheme  = errors.New("unsupported authorization scheme")
+
+	// b64token из RFC 6750, раздел 2.1
+	b64TokenPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~+/]+=*$`)
+)
+
 func secretHandler(w http.ResponseWriter, r *http.Request) {
+	if principal, ok := PrincipalFromContext(r.Context()); ok && principal.Username != "" {
+		w.Write([]byte("You are authorized, " + principal.Username + "! 🎉"))
+		return
+	}
+
 	w.Write([]byte("You are authorized! 🎉"))
 }
 
-func middelwareHandler(next http.HandlerFunc, jwtKey string) http.HandlerFunc {
+func claimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(jwt.MapClaims)
+	return claims, ok
+}
+
+// Разбирает "Bearer <token>". Токен без схемы по-прежнему принимается,
+// чтобы не ломать старых клиентов
+func parseBearerHeader(header string) (string, error) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found {
+		token = scheme
+	} else if !strings.EqualFold(scheme, "Bearer") {
+		return "", errUnsupportedAuthScheme
+	}
+
+	token = strings.TrimLeft(token, " ")
+	if !b64TokenPattern.MatchString(token) {
+		return "", errMalformedAuthorization
+	}
+
+	return token, nil
+}
+
+func bearerChallenge(bearerErr, description string) string {
+	challenge := `Bearer realm="` + bearerRealm + `"`
+	if bearerErr != "" {
+		challenge += `, error="` + bearerErr + `"`
+	}
+	if description != "" {
+		challenge += `, error_description="` + description + `"`
+	}
+	return challenge
+}
+
+// Ошибка аутентификации с заголовком WWW-Authenticate по RFC 6750
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, bearerErr, message string) {
+	w.Header().Set("WWW-Authenticate", bearerChallenge(bearerErr, message))
+	writeError(w, r, status, code, message)
+}
+
+// Отказ в доступе по токену попадает в журнал аудита. Отсутствие
+// токена не записывается: это обычный анонимный запрос
+func auditTokenRejected(r *http.Request, reason, username string) {
+	recordAuditAsync(r.Context(), AuditEvent{Type: AuditTokenRejected, Username: username, Details: map[string]string{"reason": reason}})
+}
+
+func middelwareHandler(next http.HandlerFunc, keys *KeyManager, revocations IRevocationStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		tokenStr := r.Header.Get("Authorization")
+		tokenStr, fromCookie, err := accessTokenFromRequest(r)
 
-		log.Printf("Access attempt from %s to %s", getClientIP(r), r.URL.Path)
+		logger := requestLogger(r, "auth")
+		logger.Debug("access attempt")
+
+		if err != nil {
+			logger.Info("bad Authorization header", "error", err)
+			auditTokenRejected(r, "malformed_header", "")
+			writeAuthError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, bearerErrInvalidRequest, "Malformed Authorization header")
+			return
+		}
 
+		// Без данных аутентификации код ошибки не указывается (RFC 6750, 3.1)
 		if tokenStr == "" {
-			log.Printf("Missing token from %s", getClientIP(r))
-			http.Error(w, "Missing token", http.StatusUnauthorized)
+			logger.Info("missing token")
+			writeAuthError(w, r, http.StatusUnauthorized, ErrCodeMissingToken, "", "Missing token")
 			return
 		}
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
+		// Cookie браузер подставляет сам, поэтому изменяющие запросы
+		// с ней должны подтвердить CSRF токен
+		if fromCookie && !isSafeMethod(r.Method) && !validCSRF(r) {
+			logger.Warn("CSRF check failed")
+			auditTokenRejected(r, "csrf_failed", "")
+			writeError(w, r, http.StatusForbidden, ErrCodeCSRFFailed, "Invalid CSRF token")
+			return
+		}
+
+		claims, err := keys.Parse(tokenStr)
+
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			logger.Info("expired token")
+			auditTokenRejected(r, "expired", "")
+			writeAuthError(w, r, http.StatusUnauthorized, ErrCodeTokenExpired, bearerErrInvalidToken, "Token expired")
+			return
+		}
 
-			return []byte(jwtKey), nil
-		})
+		if err != nil {
+			logger.Warn("invalid token", "error", err)
+			auditTokenRejected(r, "invalid", "")
+			writeAuthError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, bearerErrInvalidToken, "Invalid token")
+			return
+		}
 
-		if err != nil || !token.Valid {
-			log.Printf("Invalid token from %s: %v", getClientIP(r), err)
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		// Промежуточный токен 2FA годится только для /login/mfa
+		if typ, _ := claims["typ"].(string); typ == tokenTypeMFAPending {
+			logger.Warn("MFA pending token used")
+			auditTokenRejected(r, "mfa_pending", principalFromClaims(claims).Username)
+			writeAuthError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, bearerErrInvalidToken, "Invalid token")
 			return
 		}
 
-		log.Printf("User logged in")
-		next.ServeHTTP(w, r)
+		if revocations != nil {
+			jti, _ := claims["jti"].(string)
+			if jti == "" {
+				logger.Warn("token without jti")
+				auditTokenRejected(r, "missing_jti", principalFromClaims(claims).Username)
+				writeAuthError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, bearerErrInvalidToken, "Invalid token")
+				return
+			}
+
+			revoked, err := revocations.IsRevoked(r.Context(), jti)
+			if err != nil {
+				logger.Error("revocation check error", "error", err)
+				writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal error")
+				return
+			}
+
+			if revoked {
+				logger.Warn("revoked token", "jti", jti)
+				auditTokenRejected(r, "revoked", principalFromClaims(claims).Username)
+				writeAuthError(w, r, http.StatusUnauthorized, ErrCodeTokenRevoked, bearerErrInvalidToken, "Token revoked")
+				return
+			}
+		}
+
+		// Проверенные claims доступны обработчикам через claimsFromContext,
+		// а личность вызывающего — через PrincipalFromContext
+		principal := principalFromClaims(claims)
+		logger.Debug("access granted", "username", principal.Username)
+		recordAccessLogUser(r.Context(), principal.Username)
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		ctx = WithPrincipal(ctx, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
@@ -74,7 +74,7 @@ func TestMiddelware_TableDriven(t *testing.T) {
 				return req
 			},
 			expectedCode:  http.StatusUnauthorized,
-			expectedBody:  "Invalid token",
+			expectedBody:  "Token expired",
 			expectHandler: false,
 		},
 		{
@@ -95,13 +95,13 @@ func TestMiddelware_TableDriven(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockHandler := &MockSecretHandler{}
 
-			middleware := middelwareHandler(mockHandler.ServeHTTP, jwtKey)
+			middleware := middelwareHandler(mockHandler.ServeHTTP, NewHMACKeyManager([]byte(jwtKey)), nil)
 
 			req := tt.setupRequest()
 			rr := httptest.NewRecorder()
 
 			if tt.expectHandler {
-				mockHandler.On("ServeHTTP", rr, req).Return()
+				mockHandler.On("ServeHTTP", rr, mock.Anything).Return()
 			}
 
 			middleware.ServeHTTP(rr, req)
@@ -113,7 +113,7 @@ func TestMiddelware_TableDriven(t *testing.T) {
 			}
 
 			if tt.expectHandler {
-				mockHandler.AssertCalled(t, "ServeHTTP", rr, req)
+				mockHandler.AssertCalled(t, "ServeHTTP", rr, mock.Anything)
 			} else {
 				mockHandler.AssertNotCalled(t, "ServeHTTP", mock.Anything, mock.Anything)
 			}
@@ -152,3 +152,128 @@ func generateTokenWithWrongAlgorithm(username string) string {
 		return "tokenString"
 	}
 }
+
+func TestMiddelware_BearerAndClaims(t *testing.T) {
+	jwtKey := "test-secret-key"
+
+	newKeys := func() *KeyManager {
+		keys := NewHMACKeyManager([]byte(jwtKey))
+		keys.Issuer = "https://auth.example.com"
+		keys.Audience = "web"
+		keys.Leeway = 30 * time.Second
+		return keys
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token, _ := newKeys().Sign(claims)
+		return token
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"username": "testuser", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	tests := []struct {
+		name              string
+		authorization     string
+		expectedCode      int
+		expectedChallenge string
+	}{
+		{
+			name:          "Bearer scheme",
+			authorization: "Bearer " + sign(valid()),
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "Scheme is case-insensitive",
+			authorization: "bearer " + sign(valid()),
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name: "Expired within leeway",
+			authorization: "Bearer " + sign(jwt.MapClaims{
+				"username": "testuser",
+				"exp":      time.Now().Add(-10 * time.Second).Unix(),
+			}),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:              "Missing token",
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="WebAutorize", error_description="Missing token"`,
+		},
+		{
+			name:              "Unsupported scheme",
+			authorization:     "Basic dXNlcjpwYXNz",
+			expectedCode:      http.StatusBadRequest,
+			expectedChallenge: `error="invalid_request"`,
+		},
+		{
+			name:              "Malformed bearer token",
+			authorization:     "Bearer not a token",
+			expectedCode:      http.StatusBadRequest,
+			expectedChallenge: `error="invalid_request"`,
+		},
+		{
+			name: "Wrong issuer",
+			authorization: "Bearer " + sign(jwt.MapClaims{
+				"username": "testuser",
+				"iss":      "https://evil.example.com",
+				"exp":      time.Now().Add(time.Hour).Unix(),
+			}),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `error="invalid_token"`,
+		},
+		{
+			name: "Wrong audience",
+			authorization: "Bearer " + sign(jwt.MapClaims{
+				"username": "testuser",
+				"aud":      "other-service",
+				"exp":      time.Now().Add(time.Hour).Unix(),
+			}),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `error="invalid_token"`,
+		},
+		{
+			name:              "Missing exp",
+			authorization:     "Bearer " + sign(jwt.MapClaims{"username": "testuser"}),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `error="invalid_token"`,
+		},
+		{
+			name: "Not valid yet",
+			authorization: "Bearer " + sign(jwt.MapClaims{
+				"username": "testuser",
+				"nbf":      time.Now().Add(time.Hour).Unix(),
+				"exp":      time.Now().Add(2 * time.Hour).Unix(),
+			}),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `error="invalid_token"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims jwt.MapClaims
+			handler := middelwareHandler(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ = claimsFromContext(r.Context())
+			}, newKeys(), nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rr := executeHandler(handler, req)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, "testuser", claims["username"])
+				assert.Equal(t, "https://auth.example.com", claims["iss"])
+				return
+			}
+
+			assert.Contains(t, rr.Header().Get("WWW-Authenticate"), tt.expectedChallenge)
+		})
+	}
+}
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS roles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id));
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	permission TEXT NOT NULL,
+	PRIMARY KEY (role_id, permission));
+
+INSERT OR IGNORE INTO roles (name) VALUES ('user'), ('admin');
+
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT id, 'secret:read' FROM roles WHERE name IN ('user', 'admin');
+
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT id, 'users:manage' FROM roles WHERE name = 'admin';
+
+-- пользователи, созданные до появления ролей, получают базовую роль
+INSERT OR IGNORE INTO user_roles (user_id, role_id)
+SELECT users.id, roles.id FROM users, roles WHERE roles.name = 'user';
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+	key TEXT PRIMARY KEY,
+	state BLOB NOT NULL,
+	version INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits (expires_at);
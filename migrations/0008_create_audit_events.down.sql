@@ -0,0 +1,6 @@
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DROP INDEX IF EXISTS idx_audit_events_type;
+DROP INDEX IF EXISTS idx_audit_events_ip;
+DROP INDEX IF EXISTS idx_audit_events_username;
+DROP INDEX IF EXISTS idx_audit_events_occurred_at;
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY,
+	occurred_at INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	username TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	details TEXT NOT NULL DEFAULT '{}',
+	prev_hash TEXT NOT NULL,
+	hash TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at ON audit_events (occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_username ON audit_events (username);
+CREATE INDEX IF NOT EXISTS idx_audit_events_ip ON audit_events (ip);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events (type);
+
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT id, 'audit:read' FROM roles WHERE name = 'admin';
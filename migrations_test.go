@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	require.NoError(t, err)
+	return count > 0
+}
+
+func TestMigrator_UpAndDown(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	total := len(migrator.migrations)
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, total, applied)
+	assert.True(t, tableExists(t, db, "roles"))
+
+	// Повторный запуск ничего не делает
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, migrator.migrations[total-1].Version, version)
+
+	reverted, err := migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.True(t, tableExists(t, db, "users"))
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.False(t, statuses[total-1].Applied)
+	assert.True(t, statuses[0].Applied)
+
+	reverted, err = migrator.Down(ctx, total)
+	require.NoError(t, err)
+	assert.Equal(t, total-1, reverted)
+	assert.False(t, tableExists(t, db, "users"))
+
+	version, err = migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+}
+
+// Экземпляры стартуют одновременно с пустой базой: все доводят
+// схему до последней версии, и каждая миграция применяется один раз
+func TestMigrator_ConcurrentUp(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	var migrators []*Migrator
+	for i := 0; i < 8; i++ {
+		db, err := sql.Open("sqlite", sqliteDSN(path))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		migrator, err := NewMigrator(db)
+		require.NoError(t, err)
+		migrators = append(migrators, migrator)
+	}
+
+	counts := make([]int, len(migrators))
+	errs := make([]error, len(migrators))
+	var wg sync.WaitGroup
+	for i, migrator := range migrators {
+		wg.Add(1)
+		go func(i int, migrator *Migrator) {
+			defer wg.Done()
+			counts[i], errs[i] = migrator.Up(ctx)
+		}(i, migrator)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	assert.Equal(t, len(migrators[0].migrations), total)
+}
+
+func TestMigrator_LegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// База, созданная старым initDB без schema_migrations
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		password TEXT NOT NULL);
+	INSERT INTO users (username, password) VALUES ('legacy', 'hash')`)
+	require.NoError(t, err)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	repository := &SQLRepository{bd: db}
+	roles, err := repository.GetUserRoles(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, []string{RoleUser}, roles)
+}
+
+func TestLoadMigrations_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         fstest.MapFS
+		expectedError string
+	}{
+		{
+			name: "Missing up script",
+			files: fstest.MapFS{
+				"m/0001_init.down.sql": {Data: []byte("DROP TABLE t;")},
+			},
+			expectedError: "has no up script",
+		},
+		{
+			name: "Invalid version",
+			files: fstest.MapFS{
+				"m/first_init.up.sql": {Data: []byte("CREATE TABLE t (id INTEGER);")},
+			},
+			expectedError: "invalid version",
+		},
+		{
+			name: "Conflicting names",
+			files: fstest.MapFS{
+				"m/0001_init.up.sql":  {Data: []byte("CREATE TABLE t (id INTEGER);")},
+				"m/0001_other.up.sql": {Data: []byte("CREATE TABLE u (id INTEGER);")},
+			},
+			expectedError: "conflicting names",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.files, "m")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HasherArgon2id = "argon2id"
+	HasherBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+	ErrMismatchedHash    = errors.New("hashed password does not match the given password")
+)
+
+type IPasswordHasher interface {
+	GenerateFromPassword(password []byte) ([]byte, error)
+	CompareHashAndPassword([]byte, []byte) error
+	NeedsRehash([]byte) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b *BcryptHasher) cost() int {
+	if b.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return b.Cost
+}
+
+func (b *BcryptHasher) GenerateFromPassword(password []byte) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(password, b.cost())
+}
+
+func (b *BcryptHasher) CompareHashAndPassword(storedPaswsord []byte, userPassword []byte) error {
+	err := bcrypt.CompareHashAndPassword(storedPaswsord, userPassword)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *BcryptHasher) NeedsRehash(stored []byte) bool {
+	cost, err := bcrypt.Cost(stored)
+	return err != nil || cost < b.cost()
+}
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Рекомендации OWASP для argon2id с запасом по памяти
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Хэши в формате PHC: $argon2id$v=19$m=65536,t=3,p=2$<соль>$<хэш>
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+func (a *Argon2idHasher) GenerateFromPassword(password []byte) ([]byte, error) {
+	salt := make([]byte, a.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(password, salt, a.Params.Iterations, a.Params.Memory, a.Params.Parallelism, a.Params.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Params.Memory, a.Params.Iterations, a.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (a *Argon2idHasher) CompareHashAndPassword(stored []byte, password []byte) error {
+	params, salt, key, err := parseArgon2idHash(string(stored))
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return ErrMismatchedHash
+	}
+
+	return nil
+}
+
+// Пересчитываем хэш, если он посчитан с параметрами слабее текущих
+func (a *Argon2idHasher) NeedsRehash(stored []byte) bool {
+	params, _, _, err := parseArgon2idHash(string(stored))
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < a.Params.Memory ||
+		params.Iterations < a.Params.Iterations ||
+		params.Parallelism < a.Params.Parallelism ||
+		params.KeyLength < a.Params.KeyLength
+}
+
+func parseArgon2idHash(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// Выбирает алгоритм по префиксу сохранённого хэша. Новые хэши всегда
+// считаются предпочтительным алгоритмом, старые помечаются для пересчёта
+type HasherRegistry struct {
+	Preferred string
+	hashers   map[string]IPasswordHasher
+	prefixes  map[string]string
+}
+
+func NewHasherRegistry(preferred string, bcryptHasher *BcryptHasher, argon2Hasher *Argon2idHasher) (*HasherRegistry, error) {
+	registry := &HasherRegistry{
+		Preferred: preferred,
+		hashers: map[string]IPasswordHasher{
+			HasherBcrypt:   bcryptHasher,
+			HasherArgon2id: argon2Hasher,
+		},
+		prefixes: map[string]string{
+			"$2a$":       HasherBcrypt,
+			"$2b$":       HasherBcrypt,
+			"$2y$":       HasherBcrypt,
+			"$argon2id$": HasherArgon2id,
+		},
+	}
+
+	if _, ok := registry.hashers[preferred]; !ok {
+		return nil, fmt.Errorf("unknown password hasher %q", preferred)
+	}
+
+	return registry, nil
+}
+
+func (r *HasherRegistry) detect(stored []byte) (string, IPasswordHasher, error) {
+	for prefix, name := range r.prefixes {
+		if strings.HasPrefix(string(stored), prefix) {
+			return name, r.hashers[name], nil
+		}
+	}
+
+	return "", nil, ErrUnknownHashFormat
+}
+
+func (r *HasherRegistry) GenerateFromPassword(password []byte) ([]byte, error) {
+	return r.hashers[r.Preferred].GenerateFromPassword(password)
+}
+
+func (r *HasherRegistry) CompareHashAndPassword(stored []byte, password []byte) error {
+	_, hasher, err := r.detect(stored)
+	if err != nil {
+		return err
+	}
+
+	return hasher.CompareHashAndPassword(stored, password)
+}
+
+func (r *HasherRegistry) NeedsRehash(stored []byte) bool {
+	name, hasher, err := r.detect(stored)
+	if err != nil {
+		return true
+	}
+
+	return name != r.Preferred || hasher.NeedsRehash(stored)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testArgon2Params() Argon2Params {
+	params := DefaultArgon2Params()
+	params.Memory = 1024
+	params.Iterations = 1
+	params.Parallelism = 1
+	return params
+}
+
+func newTestHasherRegistry(t *testing.T, preferred string) *HasherRegistry {
+	registry, err := NewHasherRegistry(preferred,
+		&BcryptHasher{Cost: bcrypt.MinCost},
+		&Argon2idHasher{Params: testArgon2Params()})
+	require.NoError(t, err)
+	return registry
+}
+
+func TestArgon2idHasher_PHCFormat(t *testing.T) {
+	hasher := &Argon2idHasher{Params: testArgon2Params()}
+
+	hash, err := hasher.GenerateFromPassword([]byte("password123"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	assert.NoError(t, hasher.CompareHashAndPassword(hash, []byte("password123")))
+	assert.ErrorIs(t, hasher.CompareHashAndPassword(hash, []byte("wrongpassword")), ErrMismatchedHash)
+	assert.False(t, hasher.NeedsRehash(hash))
+
+	stronger := &Argon2idHasher{Params: testArgon2Params()}
+	stronger.Params.Iterations = 2
+	assert.True(t, stronger.NeedsRehash(hash))
+	assert.NoError(t, stronger.CompareHashAndPassword(hash, []byte("password123")))
+}
+
+func TestHasherRegistry_TableDriven(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	argon2Hash, err := (&Argon2idHasher{Params: testArgon2Params()}).GenerateFromPassword([]byte("password123"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		preferred     string
+		stored        []byte
+		expectRehash  bool
+		expectedError error
+	}{
+		{name: "bcrypt hash with argon2id preferred", preferred: HasherArgon2id, stored: bcryptHash, expectRehash: true},
+		{name: "argon2id hash with argon2id preferred", preferred: HasherArgon2id, stored: argon2Hash, expectRehash: false},
+		{name: "bcrypt hash with bcrypt preferred", preferred: HasherBcrypt, stored: bcryptHash, expectRehash: false},
+		{name: "argon2id hash with bcrypt preferred", preferred: HasherBcrypt, stored: argon2Hash, expectRehash: true},
+		{name: "Unknown format", preferred: HasherArgon2id, stored: []byte("plaintext"), expectRehash: true, expectedError: ErrUnknownHashFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestHasherRegistry(t, tt.preferred)
+
+			err := registry.CompareHashAndPassword(tt.stored, []byte("password123"))
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectRehash, registry.NeedsRehash(tt.stored))
+		})
+	}
+
+	_, err = NewHasherRegistry("md5", &BcryptHasher{}, &Argon2idHasher{})
+	assert.Error(t, err)
+}
+
+func TestLoginHandler_UpgradesBcryptHash(t *testing.T) {
+	ctx := context.Background()
+	repository := &SQLRepository{bd: newTestDB(t)}
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, repository.CreateUser(ctx, "legacy", string(bcryptHash)))
+
+	handler := LoginHandler{
+		Repo:   repository,
+		Hasher: newTestHasherRegistry(t, HasherArgon2id),
+		Keys:   NewHMACKeyManager([]byte("test-secret-key")),
+	}
+
+	for i := 0; i < 2; i++ {
+		req := createTestRequest(http.MethodPost, "/login", map[string]interface{}{
+			"username": "legacy",
+			"password": "password123",
+		})
+		require.Equal(t, http.StatusOK, executeHandler(handler.loginHandler, req).Code)
+
+		stored, err := repository.GetUserByUsername(ctx, "legacy")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(stored, "$argon2id$"))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcrypt молча обрезает пароль после 72 байт
+const maxPasswordBytes = 72
+
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type IPasswordPolicy interface {
+	Validate(username, password string) []PasswordViolation
+}
+
+type PasswordRule interface {
+	Check(username, password string) *PasswordViolation
+}
+
+// Проверяет все правила и возвращает все нарушения, а не только первое
+type PasswordPolicy struct {
+	Rules []PasswordRule
+}
+
+func (p *PasswordPolicy) Validate(username, password string) []PasswordViolation {
+	var violations []PasswordViolation
+	for _, rule := range p.Rules {
+		if violation := rule.Check(username, password); violation != nil {
+			violations = append(violations, *violation)
+		}
+	}
+	return violations
+}
+
+func NewPasswordPolicy(config *Config, logger *slog.Logger) *PasswordPolicy {
+	rules := []PasswordRule{
+		MinLengthRule{Min: config.PasswordMinLength},
+		MaxBytesRule{Max: maxPasswordBytes},
+		CharacterClassesRule{Min: config.PasswordMinClasses},
+		NotUsernameRule{},
+	}
+
+	if config.BreachedPasswordsDir != "" {
+		rules = append(rules, BreachedPasswordRule{
+			Checker: &HashListChecker{Dir: config.BreachedPasswordsDir},
+			Logger:  logger,
+		})
+	}
+
+	return &PasswordPolicy{Rules: rules}
+}
+
+type MinLengthRule struct {
+	Min int
+}
+
+func (r MinLengthRule) Check(username, password string) *PasswordViolation {
+	if utf8.RuneCountInString(password) >= r.Min {
+		return nil
+	}
+
+	return &PasswordViolation{
+		Rule:    "min_length",
+		Message: fmt.Sprintf("Password must be at least %d characters long", r.Min),
+	}
+}
+
+type MaxBytesRule struct {
+	Max int
+}
+
+func (r MaxBytesRule) Check(username, password string) *PasswordViolation {
+	if len(password) <= r.Max {
+		return nil
+	}
+
+	return &PasswordViolation{
+		Rule:    "max_length",
+		Message: fmt.Sprintf("Password must not be longer than %d bytes", r.Max),
+	}
+}
+
+// Классы символов: строчные, заглавные, цифры, остальное
+type CharacterClassesRule struct {
+	Min int
+}
+
+func (r CharacterClassesRule) Check(username, password string) *PasswordViolation {
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+
+	if classes >= r.Min {
+		return nil
+	}
+
+	return &PasswordViolation{
+		Rule:    "character_classes",
+		Message: fmt.Sprintf("Password must contain at least %d of: lowercase, uppercase, digits, symbols", r.Min),
+	}
+}
+
+type NotUsernameRule struct{}
+
+func (NotUsernameRule) Check(username, password string) *PasswordViolation {
+	if !strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(username)) {
+		return nil
+	}
+
+	return &PasswordViolation{
+		Rule:    "not_username",
+		Message: "Password must not be the same as the username",
+	}
+}
+
+type IBreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type BreachedPasswordRule struct {
+	Checker IBreachedPasswordChecker
+	Logger  *slog.Logger
+}
+
+// При ошибке чтения списка регистрация не блокируется, ошибка только логируется
+func (r BreachedPasswordRule) Check(username, password string) *PasswordViolation {
+	breached, err := r.Checker.IsBreached(password)
+	if err != nil {
+		loggerOrDiscard(r.Logger).Error("breached password check error", "component", "password_policy", "error", err)
+		return nil
+	}
+
+	if !breached {
+		return nil
+	}
+
+	return &PasswordViolation{
+		Rule:    "breached",
+		Message: "Password has appeared in a data breach, choose a different one",
+	}
+}
+
+// Локальная копия базы утечек в формате Pwned Passwords: файлы с именем
+// из первых 5 символов SHA-1 (ABCDE.txt), внутри строки "ОСТАТОК_ХЭША:КОЛИЧЕСТВО".
+// Читается только один файл диапазона, весь список в память не грузится
+type HashListChecker struct {
+	Dir string
+}
+
+func (c *HashListChecker) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(c.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(candidate), suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeBreachedList(t *testing.T, passwords ...string) string {
+	dir := t.TempDir()
+
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+		file, err := os.OpenFile(filepath.Join(dir, hash[:5]+".txt"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		require.NoError(t, err)
+		_, err = file.WriteString("0000000000000000000000000000000000A:1\r\n" + hash[5:] + ":42\r\n")
+		require.NoError(t, err)
+		file.Close()
+	}
+
+	return dir
+}
+
+func violatedRules(violations []PasswordViolation) []string {
+	rules := []string{}
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+func TestPasswordPolicy_TableDriven(t *testing.T) {
+	config := DefaultConfig()
+	config.PasswordMinClasses = 3
+	config.BreachedPasswordsDir = writeBreachedList(t, "Password123")
+
+	policy := NewPasswordPolicy(&config, nil)
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		expectedRules []string
+	}{
+		{
+			name:          "Strong password",
+			username:      "alice",
+			password:      "Correct-Horse-42",
+			expectedRules: []string{},
+		},
+		{
+			name:          "Too short and too simple",
+			username:      "alice",
+			password:      "abc",
+			expectedRules: []string{"min_length", "character_classes"},
+		},
+		{
+			name:          "Longer than bcrypt limit",
+			username:      "alice",
+			password:      "Aa1" + strings.Repeat("x", 70),
+			expectedRules: []string{"max_length"},
+		},
+		{
+			name:          "Multibyte characters count towards bytes",
+			username:      "alice",
+			password:      "Aa1" + strings.Repeat("ж", 35),
+			expectedRules: []string{"max_length"},
+		},
+		{
+			name:          "Same as username",
+			username:      "Alice-Smith-1",
+			password:      "alice-smith-1",
+			expectedRules: []string{"not_username"},
+		},
+		{
+			name:          "Breached password",
+			username:      "alice",
+			password:      "Password123",
+			expectedRules: []string{"breached"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedRules, violatedRules(policy.Validate(tt.username, tt.password)))
+		})
+	}
+}
+
+func TestHashListChecker_MissingRangeFile(t *testing.T) {
+	checker := &HashListChecker{Dir: t.TempDir()}
+
+	breached, err := checker.IsBreached("anything")
+	assert.NoError(t, err)
+	assert.False(t, breached)
+}
+
+func TestRegisterHandler_PasswordPolicy(t *testing.T) {
+	config := DefaultConfig()
+
+	handler := RegisterHandler{
+		UserRepo: new(MockUserRepository),
+		Hasher:   new(MockPasswordHasher),
+		Policy:   NewPasswordPolicy(&config, nil),
+	}
+
+	req := createTestRequest(http.MethodPost, "/register", map[string]interface{}{
+		"username": "shortpass",
+		"password": "short",
+	})
+	rr := executeHandler(handler.registerHandler, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, ErrCodePasswordPolicy, response.Error.Code)
+
+	rules := []string{}
+	for _, field := range response.Error.Fields {
+		assert.Equal(t, "password", field.Field)
+		rules = append(rules, field.Rule)
+	}
+	assert.Equal(t, []string{"min_length", "character_classes"}, rules)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const principalContextKey contextKey = "principal"
+
+// Кто выполняет запрос: собирается middelwareHandler из проверенного токена
+type Principal struct {
+	UserID      int64
+	Username    string
+	Roles       []string
+	Permissions []string
+	TokenID     string
+	ExpiresAt   time.Time
+}
+
+func (p Principal) HasRole(role string) bool {
+	return containsString(p.Roles, role)
+}
+
+func (p Principal) HasPermission(permission string) bool {
+	return containsString(p.Permissions, permission)
+}
+
+// У токенов, выданных до появления sub, UserID остаётся нулевым
+func principalFromClaims(claims jwt.MapClaims) Principal {
+	principal := Principal{
+		Roles:       claimStrings(claims, "roles"),
+		Permissions: claimStrings(claims, "permissions"),
+	}
+
+	principal.Username, _ = claims["username"].(string)
+	principal.TokenID, _ = claims["jti"].(string)
+
+	if sub, err := claims.GetSubject(); err == nil {
+		principal.UserID, _ = strconv.ParseInt(sub, 10, 64)
+	}
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		principal.ExpiresAt = exp.Time
+	}
+
+	return principal
+}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(Principal)
+	return principal, ok
+}
+
+func usernameFromContext(r *http.Request) string {
+	principal, _ := PrincipalFromContext(r.Context())
+	return principal.Username
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmGCRA          = "gcra"
+	AlgorithmSlidingWindow = "sliding_window"
+)
+
+// Лимит для маршрута: Limit запросов за Period. Burst — сколько запросов
+// подряд допускается для token_bucket и gcra, по умолчанию равен Limit
+type RateLimitPolicy struct {
+	Algorithm string        `yaml:"algorithm"`
+	Limit     int           `yaml:"limit"`
+	Period    time.Duration `yaml:"period"`
+	Burst     int           `yaml:"burst"`
+}
+
+func (p RateLimitPolicy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// Интервал между запросами при равномерной нагрузке
+func (p RateLimitPolicy) emissionInterval() time.Duration {
+	return p.Period / time.Duration(p.Limit)
+}
+
+func (p RateLimitPolicy) Validate() error {
+	if p.Limit <= 0 || p.Period <= 0 || p.Burst < 0 {
+		return fmt.Errorf("limit and period must be positive, burst must not be negative")
+	}
+
+	switch p.Algorithm {
+	case AlgorithmTokenBucket, AlgorithmGCRA, AlgorithmSlidingWindow:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm %q", p.Algorithm)
+}
+
+// Результат проверки лимита. Limit — размер квоты, Remaining — сколько
+// запросов ещё можно сделать сразу, ResetAfter — через сколько квота
+// восстановится полностью, RetryAfter — через сколько пройдёт следующий
+// запрос (только при отказе)
+type RateLimitDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// Алгоритм принимает состояние ключа (nil для нового) и возвращает новое
+// состояние и решение. Состояние фиксированного размера: O(1) на ключ
+type rateLimitAlgorithm interface {
+	take(state []byte, now time.Time) ([]byte, RateLimitDecision)
+	// Через сколько неиспользуемое состояние можно забыть
+	ttl() time.Duration
+}
+
+func (p RateLimitPolicy) algorithm() rateLimitAlgorithm {
+	switch p.Algorithm {
+	case AlgorithmTokenBucket:
+		return tokenBucket{p}
+	case AlgorithmGCRA:
+		return gcra{p}
+	default:
+		return slidingWindow{p}
+	}
+}
+
+// Ведро на burst токенов, пополняется со скоростью Limit/Period.
+// Состояние: количество токенов и время последнего пополнения
+type tokenBucket struct {
+	policy RateLimitPolicy
+}
+
+func (b tokenBucket) take(state []byte, now time.Time) ([]byte, RateLimitDecision) {
+	capacity := float64(b.policy.burst())
+	tokens := capacity
+
+	if len(state) == 16 {
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+		last := time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+
+		refill := float64(now.Sub(last)) / float64(b.policy.emissionInterval())
+		tokens = math.Min(capacity, tokens+math.Max(0, refill))
+	}
+
+	interval := float64(b.policy.emissionInterval())
+	decision := RateLimitDecision{Allowed: tokens >= 1, Limit: b.policy.burst()}
+
+	if decision.Allowed {
+		tokens--
+	} else {
+		decision.RetryAfter = time.Duration((1 - tokens) * interval)
+	}
+
+	decision.Remaining = int(tokens)
+	decision.ResetAfter = time.Duration((capacity - tokens) * interval)
+
+	next := make([]byte, 16)
+	binary.BigEndian.PutUint64(next, math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(next[8:], uint64(now.UnixNano()))
+	return next, decision
+}
+
+func (b tokenBucket) ttl() time.Duration {
+	return b.policy.emissionInterval() * time.Duration(b.policy.burst())
+}
+
+// Generic Cell Rate Algorithm: хранится только теоретическое время
+// прибытия (TAT) следующего запроса
+type gcra struct {
+	policy RateLimitPolicy
+}
+
+func (g gcra) take(state []byte, now time.Time) ([]byte, RateLimitDecision) {
+	interval := g.policy.emissionInterval()
+	tolerance := interval * time.Duration(g.policy.burst())
+
+	tat := now
+	if len(state) == 8 {
+		if stored := time.Unix(0, int64(binary.BigEndian.Uint64(state))); stored.After(now) {
+			tat = stored
+		}
+	}
+
+	decision := RateLimitDecision{Limit: g.policy.burst()}
+
+	newTAT := tat.Add(interval)
+	if allowAt := newTAT.Add(-tolerance); now.Before(allowAt) {
+		decision.RetryAfter = allowAt.Sub(now)
+		decision.ResetAfter = tat.Sub(now)
+		return state, decision
+	}
+
+	decision.Allowed = true
+	decision.Remaining = int(now.Sub(newTAT.Add(-tolerance)) / interval)
+	decision.ResetAfter = newTAT.Sub(now)
+
+	next := make([]byte, 8)
+	binary.BigEndian.PutUint64(next, uint64(newTAT.UnixNano()))
+	return next, decision
+}
+
+func (g gcra) ttl() time.Duration {
+	return g.policy.emissionInterval() * time.Duration(g.policy.burst())
+}
+
+// Счётчики текущего и предыдущего окна; предыдущее учитывается с весом
+// оставшейся в нём доли. Приближает скользящее окно без хранения меток
+type slidingWindow struct {
+	policy RateLimitPolicy
+}
+
+func (s slidingWindow) take(state []byte, now time.Time) ([]byte, RateLimitDecision) {
+	period := s.policy.Period
+	windowStart := now.Truncate(period)
+
+	var previous, current uint32
+	if len(state) == 16 {
+		storedStart := time.Unix(0, int64(binary.BigEndian.Uint64(state)))
+		storedCount := binary.BigEndian.Uint32(state[8:])
+		storedPrevious := binary.BigEndian.Uint32(state[12:])
+
+		switch {
+		case storedStart.Equal(windowStart):
+			previous, current = storedPrevious, storedCount
+		case storedStart.Add(period).Equal(windowStart):
+			previous = storedCount
+		}
+	}
+
+	limit := float64(s.policy.Limit)
+	elapsed := float64(now.Sub(windowStart)) / float64(period)
+	estimated := float64(previous)*(1-elapsed) + float64(current)
+
+	decision := RateLimitDecision{Allowed: estimated+1 <= limit, Limit: s.policy.Limit}
+	if decision.Allowed {
+		current++
+		estimated++
+	} else {
+		decision.RetryAfter = s.retryAfter(windowStart, previous, current).Sub(now)
+	}
+
+	decision.Remaining = int(math.Max(0, limit-estimated))
+	// Текущий счётчик перестаёт учитываться в конце следующего окна
+	decision.ResetAfter = windowStart.Add(2 * period).Sub(now)
+	if current == 0 {
+		decision.ResetAfter = windowStart.Add(period).Sub(now)
+	}
+
+	next := make([]byte, 16)
+	binary.BigEndian.PutUint64(next, uint64(windowStart.UnixNano()))
+	binary.BigEndian.PutUint32(next[8:], current)
+	binary.BigEndian.PutUint32(next[12:], previous)
+	return next, decision
+}
+
+// Момент, когда вес предыдущего окна упадёт настолько, что запрос пройдёт
+func (s slidingWindow) retryAfter(windowStart time.Time, previous, current uint32) time.Time {
+	limit := float64(s.policy.Limit)
+	period := float64(s.policy.Period)
+
+	// Внутри текущего окна: previous*(1-e) + current + 1 <= limit
+	if float64(current)+1 <= limit && previous > 0 {
+		elapsed := 1 - (limit-float64(current)-1)/float64(previous)
+		return windowStart.Add(time.Duration(elapsed * period))
+	}
+
+	// В следующем окне текущий счётчик станет предыдущим
+	windowEnd := windowStart.Add(s.policy.Period)
+	elapsed := math.Max(0, 1-(limit-1)/float64(current))
+	return windowEnd.Add(time.Duration(elapsed * period))
+}
+
+func (s slidingWindow) ttl() time.Duration {
+	return 2 * s.policy.Period
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Прогоняет запросы через алгоритм и возвращает решения
+func takeAt(algorithm rateLimitAlgorithm, start time.Time, offsets ...time.Duration) []RateLimitDecision {
+	var state []byte
+	decisions := make([]RateLimitDecision, 0, len(offsets))
+
+	for _, offset := range offsets {
+		var decision RateLimitDecision
+		state, decision = algorithm.take(state, start.Add(offset))
+		decisions = append(decisions, decision)
+	}
+
+	return decisions
+}
+
+func allowedOf(decisions []RateLimitDecision) []bool {
+	allowed := make([]bool, len(decisions))
+	for i, decision := range decisions {
+		allowed[i] = decision.Allowed
+	}
+	return allowed
+}
+
+func TestRateLimitAlgorithms(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		policy   RateLimitPolicy
+		offsets  []time.Duration
+		expected []bool
+	}{
+		{
+			name:     "Token bucket burst then refill",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmTokenBucket, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{0, 0, 0, 30 * time.Second, 30 * time.Second},
+			expected: []bool{true, true, false, true, false},
+		},
+		{
+			name:     "Token bucket smaller burst",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmTokenBucket, Limit: 60, Period: time.Minute, Burst: 1},
+			offsets:  []time.Duration{0, 500 * time.Millisecond, time.Second},
+			expected: []bool{true, false, true},
+		},
+		{
+			name:     "GCRA burst then steady rate",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{0, 0, 0, 29 * time.Second, 30 * time.Second, 30 * time.Second},
+			expected: []bool{true, true, false, false, true, false},
+		},
+		{
+			name:     "GCRA rejection does not consume",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 1, Period: time.Minute},
+			offsets:  []time.Duration{0, 10 * time.Second, 20 * time.Second, time.Minute},
+			expected: []bool{true, false, false, true},
+		},
+		{
+			name:     "Sliding window counts previous window",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{50 * time.Second, 55 * time.Second, 70 * time.Second, 90 * time.Second},
+			expected: []bool{true, true, false, true},
+		},
+		{
+			name:     "Sliding window forgets old windows",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 1, Period: time.Minute},
+			offsets:  []time.Duration{0, time.Second, 3 * time.Minute},
+			expected: []bool{true, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, allowedOf(takeAt(tt.policy.algorithm(), start, tt.offsets...)))
+		})
+	}
+}
+
+func TestRateLimitAlgorithms_Decision(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		policy   RateLimitPolicy
+		offsets  []time.Duration
+		expected RateLimitDecision // Решение для последнего запроса
+	}{
+		{
+			name:     "Token bucket allowed",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmTokenBucket, Limit: 3, Period: time.Minute},
+			offsets:  []time.Duration{0},
+			expected: RateLimitDecision{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: 20 * time.Second},
+		},
+		{
+			name:     "Token bucket rejected",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmTokenBucket, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{0, 0, 15 * time.Second},
+			expected: RateLimitDecision{Limit: 2, Remaining: 0, ResetAfter: 45 * time.Second, RetryAfter: 15 * time.Second},
+		},
+		{
+			name:     "GCRA allowed",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 3, Period: time.Minute},
+			offsets:  []time.Duration{0},
+			expected: RateLimitDecision{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: 20 * time.Second},
+		},
+		{
+			name:     "GCRA rejected",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{0, 0, 10 * time.Second},
+			expected: RateLimitDecision{Limit: 2, Remaining: 0, ResetAfter: 50 * time.Second, RetryAfter: 20 * time.Second},
+		},
+		{
+			name:     "Sliding window allowed",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 3, Period: time.Minute},
+			offsets:  []time.Duration{0},
+			expected: RateLimitDecision{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: 2 * time.Minute},
+		},
+		{
+			name:     "Sliding window rejected until previous window fades",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{0, 0, 60 * time.Second},
+			expected: RateLimitDecision{Limit: 2, Remaining: 0, ResetAfter: time.Minute, RetryAfter: 30 * time.Second},
+		},
+		{
+			name:     "Sliding window counts both windows",
+			policy:   RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 2, Period: time.Minute},
+			offsets:  []time.Duration{0, 0, 90 * time.Second, 95 * time.Second},
+			expected: RateLimitDecision{Limit: 2, Remaining: 0, ResetAfter: 85 * time.Second, RetryAfter: 25 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := takeAt(tt.policy.algorithm(), start, tt.offsets...)
+			assert.Equal(t, tt.expected, decisions[len(decisions)-1])
+		})
+	}
+}
+
+func TestRateLimitAlgorithms_ConstantState(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, name := range []string{AlgorithmTokenBucket, AlgorithmGCRA, AlgorithmSlidingWindow} {
+		t.Run(name, func(t *testing.T) {
+			algorithm := RateLimitPolicy{Algorithm: name, Limit: 1000, Period: time.Minute}.algorithm()
+
+			var state []byte
+			sizes := map[int]bool{}
+			for i := 0; i < 500; i++ {
+				state, _ = algorithm.take(state, start.Add(time.Duration(i)*time.Millisecond))
+				sizes[len(state)] = true
+			}
+
+			assert.Len(t, sizes, 1)
+		})
+	}
+}
+
+func TestRateLimitPolicy_Validate(t *testing.T) {
+	assert.NoError(t, RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 1, Period: time.Second}.Validate())
+	assert.Error(t, RateLimitPolicy{Algorithm: "leaky", Limit: 1, Period: time.Second}.Validate())
+	assert.Error(t, RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 0, Period: time.Second}.Validate())
+	assert.Error(t, RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 1, Period: time.Second, Burst: -1}.Validate())
+}
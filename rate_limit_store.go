@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreSQLite = "sqlite"
+	RateLimitStoreRedis  = "redis"
+
+	// Сколько раз повторять обновление, если ключ изменил другой экземпляр
+	maxRateLimitRetries = 10
+	// Пауза перед повтором растёт вдвое от base до max, со случайным
+	// разбросом, чтобы конкуренты не сталкивались снова в тот же момент
+	rateLimitRetryBase = time.Millisecond
+	rateLimitRetryMax  = 50 * time.Millisecond
+	// Сколько всего ждать освобождения ключа. Лимитер стоит на пути
+	// каждого входа, и долгое ожидание под нагрузкой само станет отказом
+	// в обслуживании
+	rateLimitRetryBudget = 250 * time.Millisecond
+)
+
+var (
+	errRateLimitConflict         = errors.New("rate limit state changed concurrently")
+	errRateLimitStatsUnsupported = errors.New("rate limit store does not report statistics")
+)
+
+// Хранилище состояния лимитера. Update атомарно читает состояние ключа
+// (nil, если его нет или срок истёк), применяет fn и сохраняет результат
+// на ttl. fn может вызываться повторно при конкурентном изменении ключа,
+// поэтому она не должна иметь побочных эффектов кроме последнего вызова
+type RateLimitStore interface {
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) []byte) error
+	Close() error
+}
+
+// Хранилища, которым нужна периодическая очистка: Redis удаляет
+// просроченные ключи сам
+type rateLimitPruner interface {
+	Prune(ctx context.Context) error
+}
+
+type rateLimitStatsProvider interface {
+	Stats(ctx context.Context) (RateLimitStats, error)
+}
+
+type memoryEntry struct {
+	key       string
+	state     []byte
+	expiresAt time.Time
+}
+
+// Счётчики хранилища для мониторинга
+type RateLimitStats struct {
+	Keys      int    `json:"keys"`
+	Evictions uint64 `json:"evictions"` // Вытеснено из-за лимита ключей
+	Expired   uint64 `json:"expired"`   // Удалено по истечении срока
+}
+
+// Состояние в памяти процесса: сбрасывается при перезапуске
+// и не разделяется между экземплярами. При maxKeys > 0 ключей
+// не больше maxKeys: при переполнении вытесняется давно не
+// использованный, чтобы перебор адресов не съедал память
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Спереди последние использованные
+	maxKeys int
+
+	evictions uint64
+	expired   uint64
+}
+
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		maxKeys: maxKeys,
+	}
+}
+
+func (s *MemoryRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+
+		var state []byte
+		if now.Before(entry.expiresAt) {
+			state = entry.state
+		}
+
+		entry.state = fn(state)
+		entry.expiresAt = now.Add(ttl)
+		s.lru.MoveToFront(element)
+		return nil
+	}
+
+	if s.maxKeys > 0 && s.lru.Len() >= s.maxKeys {
+		s.remove(s.lru.Back())
+		s.evictions++
+	}
+
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, state: fn(nil), expiresAt: now.Add(ttl)})
+	return nil
+}
+
+// Удаляет ключи с истёкшим сроком
+func (s *MemoryRateLimitStore) Prune(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for element := s.lru.Back(); element != nil; {
+		previous := element.Prev()
+		if !now.Before(element.Value.(*memoryEntry).expiresAt) {
+			s.remove(element)
+			s.expired++
+		}
+		element = previous
+	}
+
+	return nil
+}
+
+func (s *MemoryRateLimitStore) Stats(ctx context.Context) (RateLimitStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return RateLimitStats{Keys: s.lru.Len(), Evictions: s.evictions, Expired: s.expired}, nil
+}
+
+func (s *MemoryRateLimitStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*memoryEntry).key)
+}
+
+func (s *MemoryRateLimitStore) Close() error {
+	return nil
+}
+
+// Повтор для распределённых хранилищ: при конфликте состояние
+// перечитывается и fn применяется заново. Все попытки получают ctx
+// со сроком rateLimitRetryBudget; если он истёк, а ключ так и не
+// удалось обновить, возвращается errRateLimitConflict
+func retryRateLimitUpdate(ctx context.Context, attempt func(context.Context) error) error {
+	budgetCtx, cancel := context.WithTimeout(ctx, rateLimitRetryBudget)
+	defer cancel()
+
+	backoff := rateLimitRetryBase
+
+	var err error
+	for i := 0; i < maxRateLimitRetries; i++ {
+		err = attempt(budgetCtx)
+		if !errors.Is(err, errRateLimitConflict) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff/2 + rand.N(backoff/2+1))
+		select {
+		case <-timer.C:
+		case <-budgetCtx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff = min(2*backoff, rateLimitRetryMax)
+	}
+
+	return err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	redisKeyPrefix   = "ratelimit:"
+	redisPoolSize    = 8
+	redisDialTimeout = 2 * time.Second
+	redisIOTimeout   = time.Second
+)
+
+// Ответ Redis с ошибкой (строка, начинающаяся с "-")
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Хранилище в Redis (или совместимом сервере) по протоколу RESP.
+// Атомарность через WATCH/MULTI/EXEC: если ключ изменился между чтением
+// и записью, EXEC возвращает nil и обновление повторяется
+type RedisRateLimitStore struct {
+	addr     string
+	password string
+	pool     chan *redisConn
+}
+
+func NewRedisRateLimitStore(addr, password string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		addr:     addr,
+		password: password,
+		pool:     make(chan *redisConn, redisPoolSize),
+	}
+}
+
+func (s *RedisRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) []byte) error {
+	return retryRateLimitUpdate(ctx, func(ctx context.Context) error {
+		conn, err := s.conn(ctx)
+		if err != nil {
+			return err
+		}
+
+		conn.deadline, _ = ctx.Deadline()
+		err = s.tryUpdate(conn, redisKeyPrefix+key, ttl, fn)
+		s.release(conn, err)
+		return err
+	})
+}
+
+func (s *RedisRateLimitStore) tryUpdate(conn *redisConn, key string, ttl time.Duration, fn func([]byte) []byte) error {
+	if _, err := conn.do("WATCH", key); err != nil {
+		return err
+	}
+
+	reply, err := conn.do("GET", key)
+	if err != nil {
+		return err
+	}
+
+	state, _ := reply.([]byte)
+	next := fn(state)
+
+	if _, err := conn.do("MULTI"); err != nil {
+		return err
+	}
+
+	if _, err := conn.do("SET", key, string(next), "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		return err
+	}
+
+	reply, err = conn.do("EXEC")
+	if err != nil {
+		return err
+	}
+
+	if reply == nil {
+		return errRateLimitConflict
+	}
+
+	return nil
+}
+
+func (s *RedisRateLimitStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.pool:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if s.password != "" {
+		if _, err := conn.do("AUTH", s.password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// После ошибки соединение могло остаться посреди ответа или с открытой
+// транзакцией, поэтому в пул возвращаются только исправные
+func (s *RedisRateLimitStore) release(conn *redisConn, err error) {
+	if err != nil && !errors.Is(err, errRateLimitConflict) {
+		conn.conn.Close()
+		return
+	}
+
+	select {
+	case s.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+func (s *RedisRateLimitStore) Close() error {
+	for {
+		select {
+		case conn := <-s.pool:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// Срок текущей операции, если он раньше redisIOTimeout
+	deadline time.Time
+}
+
+// Отправляет команду и читает ответ: string для простых строк, []byte для
+// bulk строк, int64 для чисел, []interface{} для массивов, nil для null
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	deadline := time.Now().Add(redisIOTimeout)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+	c.conn.SetDeadline(deadline)
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readRESP(c.reader)
+}
+
+func readRESP(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
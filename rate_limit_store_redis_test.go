@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRedisValue struct {
+	data      []byte
+	expiresAt time.Time
+	version   int
+}
+
+// Минимальный сервер с подмножеством команд Redis, которое использует
+// RedisRateLimitStore: AUTH, PING, GET, SET PX, WATCH, MULTI, EXEC
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]fakeRedisValue
+	password string
+	listener net.Listener
+
+	// Вызывается между GET и EXEC, чтобы сымитировать конкурента
+	beforeExec func()
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &fakeRedis{values: map[string]fakeRedisValue{}, password: password, listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) get(key string) (fakeRedisValue, bool) {
+	value, ok := f.values[key]
+	if ok && time.Now().After(value.expiresAt) {
+		delete(f.values, key)
+		return fakeRedisValue{}, false
+	}
+	return value, ok
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authed := f.password == ""
+	watched := map[string]int{}
+	var queued [][]string
+	inMulti := false
+
+	for {
+		request, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, item := range request.([]interface{}) {
+			args = append(args, string(item.([]byte)))
+		}
+		command := strings.ToUpper(args[0])
+
+		if !authed && command != "AUTH" {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		if inMulti && command != "EXEC" {
+			queued = append(queued, args)
+			fmt.Fprint(conn, "+QUEUED\r\n")
+			continue
+		}
+
+		switch command {
+		case "AUTH":
+			if args[1] != f.password {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case "PING":
+			fmt.Fprint(conn, "+PONG\r\n")
+		case "WATCH":
+			f.mu.Lock()
+			value, _ := f.get(args[1])
+			watched[args[1]] = value.version
+			f.mu.Unlock()
+			fmt.Fprint(conn, "+OK\r\n")
+		case "GET":
+			f.mu.Lock()
+			value, ok := f.get(args[1])
+			f.mu.Unlock()
+			if !ok {
+				fmt.Fprint(conn, "$-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value.data), value.data)
+		case "MULTI":
+			inMulti = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case "EXEC":
+			if f.beforeExec != nil {
+				f.beforeExec()
+			}
+			f.mu.Lock()
+			conflict := false
+			for key, version := range watched {
+				if value, _ := f.get(key); value.version != version {
+					conflict = true
+				}
+			}
+			if conflict {
+				f.mu.Unlock()
+				fmt.Fprint(conn, "*-1\r\n")
+			} else {
+				for _, queuedArgs := range queued {
+					f.set(queuedArgs)
+				}
+				f.mu.Unlock()
+				fmt.Fprintf(conn, "*%d\r\n%s", len(queued), strings.Repeat("+OK\r\n", len(queued)))
+			}
+			watched, queued, inMulti = map[string]int{}, nil, false
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", command)
+		}
+	}
+}
+
+// SET key value PX ms
+func (f *fakeRedis) set(args []string) {
+	ttl, _ := strconv.Atoi(args[4])
+	previous, _ := f.get(args[1])
+	f.values[args[1]] = fakeRedisValue{
+		data:      []byte(args[2]),
+		expiresAt: time.Now().Add(time.Duration(ttl) * time.Millisecond),
+		version:   previous.version + 1,
+	}
+}
+
+// Чужая запись между чтением и EXEC должна привести к повтору, а не к потере обновления
+func TestRedisRateLimitStore_RetriesOnConflict(t *testing.T) {
+	server := startFakeRedis(t, "")
+	store := NewRedisRateLimitStore(server.addr(), "")
+	defer store.Close()
+
+	ctx := context.Background()
+	increment := func(state []byte) []byte {
+		count, _ := strconv.Atoi(string(state))
+		return []byte(strconv.Itoa(count + 1))
+	}
+
+	require.NoError(t, store.Update(ctx, "key", time.Minute, increment))
+
+	interfered := false
+	server.beforeExec = func() {
+		if interfered {
+			return
+		}
+		interfered = true
+		server.mu.Lock()
+		server.set([]string{"SET", redisKeyPrefix + "key", "10", "PX", "60000"})
+		server.mu.Unlock()
+	}
+
+	require.NoError(t, store.Update(ctx, "key", time.Minute, increment))
+
+	server.mu.Lock()
+	value, _ := server.get(redisKeyPrefix + "key")
+	server.mu.Unlock()
+	assert.Equal(t, "11", string(value.data))
+}
+
+func TestRedisRateLimitStore_Auth(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+	noop := func(state []byte) []byte { return state }
+
+	assert.Error(t, NewRedisRateLimitStore(server.addr(), "wrong").Update(context.Background(), "key", time.Minute, noop))
+	assert.NoError(t, NewRedisRateLimitStore(server.addr(), "secret").Update(context.Background(), "key", time.Minute, noop))
+}
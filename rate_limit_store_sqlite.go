@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Сколько одна попытка ждёт блокировку записи. Общий busy_timeout
+// базы (sqliteBusyTimeoutMS) для лимитера слишком велик
+const sqliteRateLimitBusyTimeoutMS = 20
+
+// Общее состояние для нескольких экземпляров, работающих с одной базой.
+// Чтение и запись идут в одной транзакции BEGIN IMMEDIATE: она сразу
+// берёт блокировку записи, так что конкурент ждёт (busy_timeout),
+// а не читает то же самое состояние
+type SQLRateLimitStore struct {
+	bd *sql.DB
+}
+
+func NewSQLRateLimitStore(db *sql.DB) *SQLRateLimitStore {
+	return &SQLRateLimitStore{bd: db}
+}
+
+func (s *SQLRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) []byte) error {
+	return retryRateLimitUpdate(ctx, func(attemptCtx context.Context) error {
+		err := s.tryUpdate(attemptCtx, key, ttl, fn)
+
+		// Срок истёк, пока ждали блокировку: это тоже конкуренция,
+		// если только не ушёл сам клиент
+		if isSQLiteBusy(err) || (err != nil && attemptCtx.Err() != nil && ctx.Err() == nil) {
+			return fmt.Errorf("%w: %v", errRateLimitConflict, err)
+		}
+		return err
+	})
+}
+
+func (s *SQLRateLimitStore) tryUpdate(ctx context.Context, key string, ttl time.Duration, fn func([]byte) []byte) error {
+	conn, err := s.bd.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Соединение вернётся в пул, поэтому общий таймаут восстанавливается
+	if _, err := conn.ExecContext(ctx, "PRAGMA busy_timeout = "+strconv.Itoa(sqliteRateLimitBusyTimeoutMS)); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA busy_timeout = "+strconv.Itoa(sqliteBusyTimeoutMS))
+
+	return immediateTx(ctx, conn, func() error {
+		now := time.Now()
+
+		var state []byte
+		var expiresAt int64
+		err := conn.QueryRowContext(ctx,
+			"SELECT state, expires_at FROM rate_limits WHERE key = ?", key).
+			Scan(&state, &expiresAt)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			state, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if expiresAt <= now.UnixMilli() {
+			state = nil
+		}
+
+		// Колонка state NOT NULL, пустое состояние храним пустым BLOB
+		next := fn(state)
+		if next == nil {
+			next = []byte{}
+		}
+
+		_, err = conn.ExecContext(ctx, `
+			INSERT INTO rate_limits (key, state, version, expires_at) VALUES (?, ?, 1, ?)
+			ON CONFLICT (key) DO UPDATE SET state = excluded.state, version = version + 1, expires_at = excluded.expires_at`,
+			key, next, now.Add(ttl).UnixMilli())
+		return err
+	})
+}
+
+// Удаляет ключи с истёкшим сроком
+func (s *SQLRateLimitStore) Prune(ctx context.Context) error {
+	_, err := s.bd.ExecContext(ctx, "DELETE FROM rate_limits WHERE expires_at <= ?", time.Now().UnixMilli())
+	return err
+}
+
+func (s *SQLRateLimitStore) Stats(ctx context.Context) (RateLimitStats, error) {
+	var stats RateLimitStats
+	err := s.bd.QueryRowContext(ctx, "SELECT COUNT(*) FROM rate_limits").Scan(&stats.Keys)
+	return stats, err
+}
+
+// База принадлежит приложению и закрывается им
+func (s *SQLRateLimitStore) Close() error {
+	return nil
+}
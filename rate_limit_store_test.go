@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimitStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryRateLimitStore(2)
+	ctx := context.Background()
+	touch := func(key string) []byte {
+		var seen []byte
+		require.NoError(t, store.Update(ctx, key, time.Minute, func(state []byte) []byte {
+			seen = state
+			return []byte(key)
+		}))
+		return seen
+	}
+
+	touch("a")
+	touch("b")
+	touch("a") // b теперь самый старый
+	touch("c")
+
+	assert.Equal(t, []byte("a"), touch("a"))
+	assert.Nil(t, touch("b"), "b should have been evicted")
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Keys)
+	assert.Equal(t, uint64(2), stats.Evictions)
+}
+
+func TestMemoryRateLimitStore_Prune(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+	ctx := context.Background()
+	noop := func(state []byte) []byte { return []byte{1} }
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.Update(ctx, fmt.Sprintf("short-%d", i), time.Millisecond, noop))
+	}
+	require.NoError(t, store.Update(ctx, "long", time.Minute, noop))
+
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, store.Prune(ctx))
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitStats{Keys: 1, Expired: 10}, stats)
+}
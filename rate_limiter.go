@@ -1,65 +1,179 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
-	"sort"
-	"sync"
+	"strconv"
 	"time"
 )
 
+const rateLimitContentionRetry = time.Second
+
+// Состояние хранится в RateLimitStore, поэтому лимит может быть общим
+// для нескольких экземпляров сервиса. Маршруты без своей политики
+// делят общий лимит по умолчанию
 type RateLimiter struct {
-	mu          sync.Mutex
-	requests    map[string][]time.Time
-	maxRequests int
-	window      time.Duration
+	store         RateLimitStore
+	defaultPolicy RateLimitPolicy
+	routes        map[string]RateLimitPolicy
+
+	Logger *slog.Logger
+
+	stop chan struct{}
+	done chan struct{}
 }
 
 func NewRateLimiter(maxRequests int, window time.Duration) *RateLimiter {
+	policy := RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: maxRequests, Period: window}
+	return NewRateLimiterWithStore(NewMemoryRateLimitStore(0), policy, nil)
+}
+
+func NewRateLimiterWithStore(store RateLimitStore, defaultPolicy RateLimitPolicy, routes map[string]RateLimitPolicy) *RateLimiter {
 	return &RateLimiter{
-		requests:    make(map[string][]time.Time),
-		maxRequests: maxRequests,
-		window:      window,
+		store:         store,
+		defaultPolicy: defaultPolicy,
+		routes:        routes,
 	}
 }
 
-func (rl *RateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-	windowStart := now.Add(-rl.window)
+// Проверка по общему лимиту
+func (rl *RateLimiter) Allow(ctx context.Context, ip string) RateLimitDecision {
+	return rl.allow(ctx, ip, rl.defaultPolicy)
+}
 
-	// Получаем таймстемпы для IP
-	timestamps, exists := rl.requests[ip]
-	if !exists {
-		timestamps = []time.Time{}
+// Проверка по политике маршрута, если она задана; ключи разных
+// маршрутов не пересекаются
+func (rl *RateLimiter) AllowRoute(ctx context.Context, route, ip string) RateLimitDecision {
+	policy, ok := rl.routes[route]
+	if !ok {
+		return rl.Allow(ctx, ip)
 	}
+	return rl.allow(ctx, route+"|"+ip, policy)
+}
+
+// При недоступности хранилища запрос пропускается: отказ лимитера
+// не должен класть вход в систему. Limit в таком решении нулевой,
+// остаток квоты неизвестен. Но если ключ не удалось обновить из-за
+// конкурентов, запрос отклоняется: иначе параллельные запросы обходили
+// бы лимит как раз тогда, когда их много. Запрос, клиент которого
+// ушёл до решения, тоже не пропускается
+func (rl *RateLimiter) allow(ctx context.Context, key string, policy RateLimitPolicy) RateLimitDecision {
+	algorithm := policy.algorithm()
+	var decision RateLimitDecision
 
-	// Находим индекс первого таймстемпа, который ещё в окне
-	// (все таймстемпы после этого индекса актуальны)
-	firstValidIndex := sort.Search(len(timestamps), func(i int) bool {
-		return timestamps[i].After(windowStart)
+	err := rl.store.Update(ctx, key, algorithm.ttl(), func(state []byte) []byte {
+		var next []byte
+		next, decision = algorithm.take(state, time.Now())
+		return next
 	})
-	validTimestamps := timestamps[firstValidIndex:]
 
-	// Проверяем лимит
-	if len(validTimestamps) >= rl.maxRequests {
-		return false
+	if err != nil && ctx.Err() != nil {
+		return RateLimitDecision{RetryAfter: rateLimitContentionRetry}
+	}
+
+	if errors.Is(err, errRateLimitConflict) {
+		loggerOrDiscard(rl.Logger).Warn("rate limit contention, request denied", "key", key, "error", err)
+		return RateLimitDecision{RetryAfter: rateLimitContentionRetry}
+	}
+
+	if err != nil {
+		loggerOrDiscard(rl.Logger).Error("rate limit store error", "key", key, "error", err)
+		return RateLimitDecision{Allowed: true}
+	}
+
+	return decision
+}
+
+// Фоновая очистка просроченных ключей, если хранилище её требует
+func (rl *RateLimiter) Start(interval time.Duration) {
+	pruner, ok := rl.store.(rateLimitPruner)
+	if !ok {
+		return
+	}
+
+	rl.stop = make(chan struct{})
+	rl.done = make(chan struct{})
+
+	go func() {
+		defer close(rl.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := pruner.Prune(context.Background()); err != nil {
+					loggerOrDiscard(rl.Logger).Error("rate limit pruning error", "error", err)
+				}
+			case <-rl.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (rl *RateLimiter) Stop() {
+	if rl.stop == nil {
+		return
 	}
 
-	// Разрешаем запрос, добавляем текущую метку и сохраняем только актуальные
-	validTimestamps = append(validTimestamps, now)
-	rl.requests[ip] = validTimestamps // Сохраняем уже очищенный срез
+	close(rl.stop)
+	<-rl.done
+	rl.stop = nil
+}
 
-	return true
+func (rl *RateLimiter) Stats(ctx context.Context) (RateLimitStats, error) {
+	provider, ok := rl.store.(rateLimitStatsProvider)
+	if !ok {
+		return RateLimitStats{}, errRateLimitStatsUnsupported
+	}
+	return provider.Stats(ctx)
+}
+
+func (rl *RateLimiter) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats, err := rl.Stats(r.Context())
+	if errors.Is(err, errRateLimitStatsUnsupported) {
+		writeError(w, r, http.StatusNotFound, ErrCodeNotFound, "Rate limit store does not report statistics")
+		return
+	}
+
+	if err != nil {
+		requestLogger(r, "rate_limiter").Error("rate limit stats error", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Останавливает очистку и закрывает хранилище
+func (rl *RateLimiter) Close() error {
+	rl.Stop()
+	return rl.store.Close()
 }
 
 func RateLimitMiddleware(limiter *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ip := getClientIP(r)
 
-		if !limiter.Allow(ip) {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		decision := limiter.AllowRoute(r.Context(), r.URL.Path, ip)
+		writeRateLimitHeaders(w, decision)
+		recordAccessLogRateLimit(r.Context(), decision)
+
+		if !decision.Allowed {
+			recordAuditAsync(r.Context(), AuditEvent{Type: AuditRateLimited, IP: ip, Details: map[string]string{"path": r.URL.Path}})
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			writeError(w, r, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many requests")
 			return
 		}
 
@@ -67,9 +181,21 @@ func RateLimitMiddleware(limiter *RateLimiter, next http.HandlerFunc) http.Handl
 	}
 }
 
-func getClientIP(r *http.Request) string {
-	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
-		return ip
+// Заголовки по черновику IETF RateLimit header fields
+func writeRateLimitHeaders(w http.ResponseWriter, decision RateLimitDecision) {
+	if decision.Limit == 0 {
+		return
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.ResetAfter)))
+}
+
+// Секунды с округлением вверх: клиент, подождавший столько, не получит отказ
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
 	}
-	return r.RemoteAddr
+	return int((d + time.Second - 1) / time.Second)
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Каждый вызов возвращает новое хранилище, смотрящее на одно и то же
+// общее состояние, как два экземпляра сервиса
+func rateLimitStoreFactories(t *testing.T) map[string]func() RateLimitStore {
+	memory := NewMemoryRateLimitStore(0)
+
+	config := DefaultConfig()
+	config.DBPath = filepath.Join(t.TempDir(), "limits.db")
+
+	redis := startFakeRedis(t, "")
+
+	return map[string]func() RateLimitStore{
+		RateLimitStoreMemory: func() RateLimitStore { return memory },
+		RateLimitStoreSQLite: func() RateLimitStore {
+			db, err := initDB(context.Background(), &config)
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return NewSQLRateLimitStore(db)
+		},
+		RateLimitStoreRedis: func() RateLimitStore {
+			store := NewRedisRateLimitStore(redis.addr(), "")
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+}
+
+func TestRateLimiter_SharedStores(t *testing.T) {
+	for name, newStore := range rateLimitStoreFactories(t) {
+		t.Run(name, func(t *testing.T) {
+			first := NewRateLimiterWithStore(newStore(), RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 3, Period: time.Minute}, nil)
+			second := NewRateLimiterWithStore(newStore(), RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 3, Period: time.Minute}, nil)
+
+			assert.True(t, first.Allow(context.Background(), "10.0.0.1").Allowed)
+			assert.True(t, second.Allow(context.Background(), "10.0.0.1").Allowed)
+			assert.True(t, first.Allow(context.Background(), "10.0.0.1").Allowed)
+
+			// Лимит общий для обоих экземпляров
+			assert.False(t, second.Allow(context.Background(), "10.0.0.1").Allowed)
+			assert.False(t, first.Allow(context.Background(), "10.0.0.1").Allowed)
+
+			// Другой ключ считается отдельно
+			assert.True(t, second.Allow(context.Background(), "10.0.0.2").Allowed)
+		})
+	}
+}
+
+func TestRateLimiter_WindowExpires(t *testing.T) {
+	for name, newStore := range rateLimitStoreFactories(t) {
+		t.Run(name, func(t *testing.T) {
+			limiter := NewRateLimiterWithStore(newStore(), RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 1, Period: 500 * time.Millisecond}, nil)
+
+			assert.True(t, limiter.Allow(context.Background(), "10.0.0.1").Allowed)
+			assert.False(t, limiter.Allow(context.Background(), "10.0.0.1").Allowed)
+
+			time.Sleep(600 * time.Millisecond)
+			assert.True(t, limiter.Allow(context.Background(), "10.0.0.1").Allowed)
+		})
+	}
+}
+
+// Параллельные запросы к одному ключу не должны пропустить больше лимита
+func TestRateLimiter_ConcurrentEnforcement(t *testing.T) {
+	const limit, workers = 5, 100
+
+	for name, newStore := range rateLimitStoreFactories(t) {
+		t.Run(name, func(t *testing.T) {
+			policy := RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: limit, Period: time.Hour}
+			limiters := []*RateLimiter{
+				NewRateLimiterWithStore(newStore(), policy, nil),
+				NewRateLimiterWithStore(newStore(), policy, nil),
+			}
+
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(limiter *RateLimiter) {
+					defer wg.Done()
+					if limiter.Allow(context.Background(), "10.0.0.1").Allowed {
+						allowed.Add(1)
+					}
+				}(limiters[i%len(limiters)])
+			}
+			wg.Wait()
+
+			assert.LessOrEqual(t, int(allowed.Load()), limit)
+			assert.Positive(t, allowed.Load())
+		})
+	}
+}
+
+func TestRateLimiter_DeniesOnContention(t *testing.T) {
+	limiter := NewRateLimiterWithStore(conflictingRateLimitStore{}, RateLimitPolicy{Limit: 1, Period: time.Minute}, nil)
+
+	decision := limiter.Allow(context.Background(), "10.0.0.1")
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, rateLimitContentionRetry, decision.RetryAfter)
+}
+
+// Пока базу держит другой писатель, лимитер отказывает быстро,
+// а не ждёт busy_timeout на каждой попытке
+func TestRateLimiter_SQLiteLockedDeniesQuickly(t *testing.T) {
+	db := newTestDB(t)
+	limiter := NewRateLimiterWithStore(NewSQLRateLimitStore(db), RateLimitPolicy{Limit: 5, Period: time.Minute}, nil)
+
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+	require.NoError(t, err)
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	start := time.Now()
+	decision := limiter.Allow(context.Background(), "10.0.0.1")
+
+	assert.False(t, decision.Allowed)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRateLimiter_DeniesWhenClientGone(t *testing.T) {
+	// Без отменённого контекста недоступное хранилище пропускает запрос
+	limiter := NewRateLimiterWithStore(NewRedisRateLimitStore("127.0.0.1:1", ""), RateLimitPolicy{Limit: 1, Period: time.Minute}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, limiter.Allow(ctx, "10.0.0.1").Allowed)
+}
+
+func TestRateLimiter_FailsOpen(t *testing.T) {
+	// Порт, на котором никто не слушает
+	limiter := NewRateLimiterWithStore(NewRedisRateLimitStore("127.0.0.1:1", ""), RateLimitPolicy{Limit: 1, Period: time.Minute}, nil)
+
+	assert.True(t, limiter.Allow(context.Background(), "10.0.0.1").Allowed)
+	assert.True(t, limiter.Allow(context.Background(), "10.0.0.1").Allowed)
+}
+
+func TestRateLimitMiddleware_Blocks(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Minute)
+	handler := RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {})
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		codes = append(codes, executeHandler(handler, httptest.NewRequest(http.MethodPost, "/login", nil)).Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestRateLimitMiddleware_RoutePolicies(t *testing.T) {
+	limiter := NewRateLimiterWithStore(NewMemoryRateLimitStore(0),
+		RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 3, Period: time.Minute},
+		map[string]RateLimitPolicy{
+			"/register": {Algorithm: AlgorithmGCRA, Limit: 1, Period: time.Hour},
+		})
+	handler := RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(path string) int {
+		return executeHandler(handler, httptest.NewRequest(http.MethodPost, path, nil)).Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("/register"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/register"))
+
+	// Отказ на /register не расходует общий лимит
+	assert.Equal(t, http.StatusOK, request("/login"))
+	assert.Equal(t, http.StatusOK, request("/login/mfa"))
+	assert.Equal(t, http.StatusOK, request("/login"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/token/refresh"))
+}
+
+func TestRateLimiter_JanitorPrunesExpiredKeys(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+	limiter := NewRateLimiterWithStore(store, RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 1, Period: 10 * time.Millisecond}, nil)
+
+	for i := 0; i < 5; i++ {
+		limiter.Allow(context.Background(), fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	limiter.Start(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	assert.Eventually(t, func() bool {
+		stats, err := limiter.Stats(context.Background())
+		return err == nil && stats.Keys == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRateLimiter_StatsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		store          RateLimitStore
+		expectedStatus int
+	}{
+		{
+			name:           "Memory store",
+			store:          NewMemoryRateLimitStore(0),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Store without statistics",
+			store:          NewRedisRateLimitStore("127.0.0.1:1", ""),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiterWithStore(tt.store, RateLimitPolicy{Limit: 1, Period: time.Minute}, nil)
+			limiter.Allow(context.Background(), "10.0.0.1")
+
+			rr := executeHandler(limiter.statsHandler, httptest.NewRequest(http.MethodGet, "/admin/rate-limits", nil))
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var stats RateLimitStats
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+				assert.Equal(t, 1, stats.Keys)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_Headers(t *testing.T) {
+	limiter := NewRateLimiterWithStore(NewMemoryRateLimitStore(0), RateLimitPolicy{Algorithm: AlgorithmGCRA, Limit: 2, Period: time.Minute}, nil)
+	handler := RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		expectedStatus    int
+		expectedRemaining string
+		expectedReset     string
+		expectedRetry     string
+	}{
+		{http.StatusOK, "1", "30", ""},
+		{http.StatusOK, "0", "60", ""},
+		{http.StatusTooManyRequests, "0", "60", "30"},
+	}
+
+	for _, tt := range tests {
+		rr := executeHandler(handler, httptest.NewRequest(http.MethodPost, "/login", nil))
+
+		assert.Equal(t, tt.expectedStatus, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, tt.expectedRemaining, rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, tt.expectedReset, rr.Header().Get("RateLimit-Reset"))
+		assert.Equal(t, tt.expectedRetry, rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitMiddleware_NoHeadersWhenStoreFails(t *testing.T) {
+	limiter := NewRateLimiterWithStore(NewRedisRateLimitStore("127.0.0.1:1", ""), RateLimitPolicy{Limit: 1, Period: time.Minute}, nil)
+	handler := RateLimitMiddleware(limiter, func(w http.ResponseWriter, r *http.Request) {})
+
+	rr := executeHandler(handler, httptest.NewRequest(http.MethodPost, "/login", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+}
+
+// Хранилище, в котором ключ всегда успевает изменить кто-то другой
+type conflictingRateLimitStore struct{}
+
+func (conflictingRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) []byte) error {
+	return retryRateLimitUpdate(ctx, func(context.Context) error { return errRateLimitConflict })
+}
+
+func (conflictingRateLimitStore) Close() error {
+	return nil
+}
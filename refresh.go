@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (l *LoginHandler) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
+		return
+	}
+
+	var request RefreshRequest
+	fromCookie := false
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil && cookie.Value != "" && r.ContentLength == 0 {
+		if !validCSRF(r) {
+			writeError(w, r, http.StatusForbidden, ErrCodeCSRFFailed, "Invalid CSRF token")
+			return
+		}
+		request.RefreshToken = cookie.Value
+		fromCookie = true
+	} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
+		return
+	}
+
+	tokenHash := hashRefreshToken(request.RefreshToken)
+
+	stored, err := l.RefreshRepo.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid refresh token")
+		return
+	}
+
+	if stored.Revoked {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid refresh token")
+		return
+	}
+
+	if stored.Used {
+		l.revokeReusedFamily(ctx, stored)
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid refresh token")
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeTokenExpired, "Refresh token expired")
+		return
+	}
+
+	marked, err := l.RefreshRepo.MarkRefreshTokenUsed(ctx, tokenHash)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+		return
+	}
+
+	// Токен успел использовать параллельный запрос
+	if !marked {
+		l.revokeReusedFamily(ctx, stored)
+		writeError(w, r, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid refresh token")
+		return
+	}
+
+	accessToken, permissions, err := l.generateAccessToken(ctx, stored.Username)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+		return
+	}
+
+	refreshToken, err := l.issueRefreshToken(ctx, stored.Username, stored.FamilyID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+		return
+	}
+
+	if fromCookie {
+		l.writeSessionCookies(w, r, accessToken, refreshToken, permissions)
+		return
+	}
+
+	l.writeTokenResponse(w, accessToken, refreshToken, permissions)
+}
+
+func (l *LoginHandler) revokeReusedFamily(ctx context.Context, token RefreshToken) {
+	loggerFromContext(ctx).Warn("refresh token reuse detected, revoking family", "component", "refresh", "username", token.Username, "family_id", token.FamilyID)
+	recordAudit(ctx, AuditEvent{Type: AuditRefreshTokenReuse, Username: token.Username, Details: map[string]string{"family_id": token.FamilyID}})
+
+	if err := l.RefreshRepo.RevokeTokenFamily(ctx, token.FamilyID); err != nil {
+		loggerFromContext(ctx).Error("revoking token family error", "component", "refresh", "family_id", token.FamilyID, "error", err)
+	}
+}
+
+func (l *LoginHandler) refreshTTL() time.Duration {
+	if l.RefreshTTL <= 0 {
+		return defaultRefreshTTL
+	}
+	return l.RefreshTTL
+}
+
+func (l *LoginHandler) issueRefreshToken(ctx context.Context, username, familyID string) (string, error) {
+	ttl := l.refreshTTL()
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = l.RefreshRepo.CreateRefreshToken(ctx, RefreshToken{
+		TokenHash: hashRefreshToken(token),
+		Username:  username,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+
+	return token, err
+}
+
+func generateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// В базе храним только хэш, чтобы утечка БД не давала рабочих токенов
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTokenFamilyID() string {
+	return uuid.NewString()
+}
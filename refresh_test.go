@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRefreshHandler_TableDriven(t *testing.T) {
+	const refreshToken = "old-refresh-token"
+	tokenHash := hashRefreshToken(refreshToken)
+
+	validToken := RefreshToken{
+		TokenHash: tokenHash,
+		Username:  "validuser",
+		FamilyID:  "family-1",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	tests := []struct {
+		name         string
+		requestBody  map[string]interface{}
+		setupMocks   func(*MockRefreshTokenRepository)
+		expectedCode int
+		expectedBody string
+		checkTokens  bool
+	}{
+		{
+			name:        "Successful rotation",
+			requestBody: map[string]interface{}{"refresh_token": refreshToken},
+			setupMocks: func(mrr *MockRefreshTokenRepository) {
+				mrr.On("GetRefreshToken", tokenHash).Return(validToken, nil)
+				mrr.On("MarkRefreshTokenUsed", tokenHash).Return(true, nil)
+				mrr.On("CreateRefreshToken", "validuser", "family-1").Return(nil)
+			},
+			expectedCode: http.StatusOK,
+			checkTokens:  true,
+		},
+		{
+			name:        "Reused token revokes family",
+			requestBody: map[string]interface{}{"refresh_token": refreshToken},
+			setupMocks: func(mrr *MockRefreshTokenRepository) {
+				used := validToken
+				used.Used = true
+				mrr.On("GetRefreshToken", tokenHash).Return(used, nil)
+				mrr.On("RevokeTokenFamily", "family-1").Return(nil)
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "Invalid refresh token",
+		},
+		{
+			name:        "Concurrent reuse revokes family",
+			requestBody: map[string]interface{}{"refresh_token": refreshToken},
+			setupMocks: func(mrr *MockRefreshTokenRepository) {
+				mrr.On("GetRefreshToken", tokenHash).Return(validToken, nil)
+				mrr.On("MarkRefreshTokenUsed", tokenHash).Return(false, nil)
+				mrr.On("RevokeTokenFamily", "family-1").Return(nil)
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "Invalid refresh token",
+		},
+		{
+			name:        "Revoked token",
+			requestBody: map[string]interface{}{"refresh_token": refreshToken},
+			setupMocks: func(mrr *MockRefreshTokenRepository) {
+				revoked := validToken
+				revoked.Revoked = true
+				mrr.On("GetRefreshToken", tokenHash).Return(revoked, nil)
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "Invalid refresh token",
+		},
+		{
+			name:        "Expired token",
+			requestBody: map[string]interface{}{"refresh_token": refreshToken},
+			setupMocks: func(mrr *MockRefreshTokenRepository) {
+				expired := validToken
+				expired.ExpiresAt = time.Now().Add(-time.Minute)
+				mrr.On("GetRefreshToken", tokenHash).Return(expired, nil)
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "Refresh token expired",
+		},
+		{
+			name:        "Unknown token",
+			requestBody: map[string]interface{}{"refresh_token": "unknown"},
+			setupMocks: func(mrr *MockRefreshTokenRepository) {
+				mrr.On("GetRefreshToken", mock.Anything).Return(RefreshToken{}, sql.ErrNoRows)
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "Invalid refresh token",
+		},
+		{
+			name:         "Empty token",
+			requestBody:  map[string]interface{}{"refresh_token": ""},
+			setupMocks:   func(mrr *MockRefreshTokenRepository) {},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "Invalid input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRefreshRepository := &MockRefreshTokenRepository{}
+
+			tt.setupMocks(mockRefreshRepository)
+
+			handler := LoginHandler{
+				Keys:        NewHMACKeyManager([]byte("test-secret-key")),
+				RefreshRepo: mockRefreshRepository,
+			}
+
+			req := createTestRequest(http.MethodPost, "/token/refresh", tt.requestBody)
+			rr := executeHandler(handler.refreshHandler, req)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+
+			if tt.expectedBody != "" {
+				assert.Contains(t, rr.Body.String(), tt.expectedBody)
+			}
+
+			if tt.checkTokens {
+				var response TokenResponse
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+				assert.NotEmpty(t, response.AccessToken)
+				assert.NotEmpty(t, response.RefreshToken)
+				assert.NotEqual(t, refreshToken, response.RefreshToken)
+			}
+
+			mockRefreshRepository.AssertExpectations(t)
+		})
+	}
+}
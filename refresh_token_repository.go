@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RefreshToken struct {
+	TokenHash string
+	Username  string
+	FamilyID  string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+type IRefreshTokenRepository interface {
+	CreateRefreshToken(context.Context, RefreshToken) error
+	GetRefreshToken(context.Context, string) (RefreshToken, error)
+	MarkRefreshTokenUsed(context.Context, string) (bool, error)
+	RevokeTokenFamily(context.Context, string) error
+}
+
+type SQLRefreshTokenRepository struct {
+	bd *sql.DB
+}
+
+func (r *SQLRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	_, err := r.bd.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (token_hash, username, family_id, expires_at) VALUES (?, ?, ?, ?)",
+		token.TokenHash, token.Username, token.FamilyID, token.ExpiresAt.Unix())
+	return err
+}
+
+func (r *SQLRefreshTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	var token RefreshToken
+	var expiresAt int64
+
+	row := r.bd.QueryRowContext(ctx,
+		"SELECT token_hash, username, family_id, expires_at, used, revoked FROM refresh_tokens WHERE token_hash = ?",
+		tokenHash)
+	err := row.Scan(&token.TokenHash, &token.Username, &token.FamilyID, &expiresAt, &token.Used, &token.Revoked)
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return token, err
+}
+
+// Помечает токен использованным. Возвращает false, если токен уже был использован
+// (например, параллельным запросом) — это повторное использование.
+func (r *SQLRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, tokenHash string) (bool, error) {
+	result, err := r.bd.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used = 1 WHERE token_hash = ? AND used = 0",
+		tokenHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (r *SQLRefreshTokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	_, err := r.bd.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = 1 WHERE family_id = ?", familyID)
+	return err
+}
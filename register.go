@@ -3,72 +3,61 @@ package main
 import (
 	"encoding/json"
 	"net/http"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-type IPasswordHasher interface {
-	GenerateFromPassword(password []byte, cost int) ([]byte, error)
-	CompareHashAndPassword([]byte, []byte) error
-}
-
 type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-type BcryptHasher struct {
-}
-
-func (b *BcryptHasher) GenerateFromPassword(password []byte, cost int) ([]byte, error) {
-	return bcrypt.GenerateFromPassword(password, cost)
-}
-
-func (b *BcryptHasher) CompareHashAndPassword(storedPaswsord []byte, userPassword []byte) error {
-	err := bcrypt.CompareHashAndPassword(storedPaswsord, userPassword)
-
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 type RegisterHandler struct {
 	UserRepo IRepository
 	Hasher   IPasswordHasher
+	Policy   IPasswordPolicy
 }
 
 func (h *RegisterHandler) registerHandler(w http.ResponseWriter, r *http.Request) {
 	cxt := r.Context()
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Use post", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Use post")
 		return
 	}
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
 		return
 	}
 
 	if user.Username == "" || user.Password == "" {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidInput, "Invalid input")
 		return
 	}
 
-	hashedPassword, err := h.Hasher.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if h.Policy != nil {
+		if violations := h.Policy.Validate(user.Username, user.Password); len(violations) > 0 {
+			apiErr := NewAPIError(http.StatusBadRequest, ErrCodePasswordPolicy, "Password policy violation")
+			for _, violation := range violations {
+				apiErr.WithFields(FieldError{Field: "password", Rule: violation.Rule, Message: violation.Message})
+			}
+			writeAPIError(w, r, apiErr)
+			return
+		}
+	}
+
+	hashedPassword, err := h.Hasher.GenerateFromPassword([]byte(user.Password))
 	if err != nil {
-		http.Error(w, "Hashing password error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Hashing password error")
 		return
 	}
 
 	err = h.UserRepo.CreateUser(cxt, user.Username, string(hashedPassword))
 	if err != nil {
-		http.Error(w, "Username already exist", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeUserExists, "Username already exists")
 		return
 	}
 
+	recordAudit(cxt, AuditEvent{Type: AuditRegister, Username: user.Username})
 	w.Write([]byte("User registrated successfuly"))
 }
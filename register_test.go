@@ -7,7 +7,6 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func TestRegisterHandler_TableDriven(t *testing.T) {
@@ -26,7 +25,7 @@ func TestRegisterHandler_TableDriven(t *testing.T) {
 			},
 			setupMocks: func(mur *MockUserRepository, mph *MockPasswordHasher) {
 				mur.On("CreateUser", "validuser", "hashed_password").Return(nil)
-				mph.On("GenerateFromPassword", []byte("password123"), bcrypt.DefaultCost).Return([]byte("hashed_password"), nil)
+				mph.On("GenerateFromPassword", []byte("password123")).Return([]byte("hashed_password"), nil)
 			},
 			expectedCode: http.StatusOK,
 			expectedBody: "User registrated successfuly",
@@ -59,7 +58,7 @@ func TestRegisterHandler_TableDriven(t *testing.T) {
 				"password": "password123",
 			},
 			setupMocks: func(mockRepo *MockUserRepository, mockHasher *MockPasswordHasher) {
-				mockHasher.On("GenerateFromPassword", mock.Anything, mock.Anything).
+				mockHasher.On("GenerateFromPassword", mock.Anything).
 					Return([]byte("hashed_password"), nil)
 				mockRepo.On("CreateUser", "existinguser", "hashed_password").
 					Return(errors.New("ErrUserExists"))
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type IRevocationStore interface {
+	Revoke(context.Context, string, time.Time) error
+	IsRevoked(context.Context, string) (bool, error)
+}
+
+// Отозванные jti храним в SQLite. Найденные отзывы кэшируются в памяти,
+// а промах проверяется по базе: отзыв мог записать другой экземпляр
+// сервиса с той же базой
+type SQLRevocationStore struct {
+	bd    *sql.DB
+	mu    sync.RWMutex
+	cache map[string]time.Time
+	stop  chan struct{}
+	done  chan struct{}
+
+	Logger *slog.Logger
+}
+
+func NewSQLRevocationStore(ctx context.Context, db *sql.DB) (*SQLRevocationStore, error) {
+	store := &SQLRevocationStore{
+		bd:    db,
+		cache: make(map[string]time.Time),
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > ?", time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jti string
+		var expiresAt int64
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		store.cache[jti] = time.Unix(expiresAt, 0)
+	}
+
+	return store, rows.Err()
+}
+
+func (s *SQLRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.bd.ExecContext(ctx,
+		"INSERT OR REPLACE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)",
+		jti, expiresAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.cache[jti] = expiresAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *SQLRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	_, revoked := s.cache[jti]
+	s.mu.RUnlock()
+
+	if revoked {
+		return true, nil
+	}
+
+	var expiresAt int64
+	err := s.bd.QueryRowContext(ctx,
+		"SELECT expires_at FROM revoked_tokens WHERE jti = ? AND expires_at > ?",
+		jti, time.Now().Unix()).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.cache[jti] = time.Unix(expiresAt, 0)
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// Удаляет записи, у которых истёк срок действия токена: такой токен
+// всё равно не пройдёт проверку exp, хранить его больше не нужно
+func (s *SQLRevocationStore) Prune(ctx context.Context) error {
+	now := time.Now()
+
+	_, err := s.bd.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= ?", now.Unix())
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for jti, expiresAt := range s.cache {
+		if !expiresAt.After(now) {
+			delete(s.cache, jti)
+		}
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *SQLRevocationStore) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Prune(context.Background()); err != nil {
+					loggerOrDiscard(s.Logger).Error("revoked tokens pruning error", "error", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *SQLRevocationStore) Stop() {
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
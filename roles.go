@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+
+	PermissionSecretRead  = "secret:read"
+	PermissionUsersManage = "users:manage"
+	PermissionAuditRead   = "audit:read"
+)
+
+type IRoleRepository interface {
+	GetUserRoles(context.Context, string) ([]string, error)
+	GetUserPermissions(context.Context, string) ([]string, error)
+	AssignRole(context.Context, string, string) error
+}
+
+// Роли и права кладутся в токен при логине, поэтому проверка
+// не требует обращения к базе. Изменения ролей вступают в силу
+// со следующим access токеном
+func RequireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return requireClaim(next, "roles", "role", roles)
+}
+
+func RequirePermission(next http.HandlerFunc, permissions ...string) http.HandlerFunc {
+	return requireClaim(next, "permissions", "permission", permissions)
+}
+
+// Пропускает запрос, если в токене есть хотя бы одно из требуемых значений
+func requireClaim(next http.HandlerFunc, claim, kind string, required []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, ErrCodeMissingToken, "Missing token")
+			return
+		}
+
+		granted := claimStrings(claims, claim)
+		for _, value := range required {
+			if containsString(granted, value) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		username, _ := claims["username"].(string)
+		requestLogger(r, "auth").Warn("access denied", "username", username, "missing_"+kind, required)
+		writeAuthError(w, r, http.StatusForbidden, ErrCodeForbidden, bearerErrInsufficientScope, "Forbidden: requires "+kind+" "+strings.Join(required, " or "))
+	}
+}
+
+// После разбора JWT массивы приходят как []interface{}
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	raw, ok := claims[name].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	values := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
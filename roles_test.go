@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireRole_TableDriven(t *testing.T) {
+	tests := []struct {
+		name          string
+		claims        jwt.MapClaims
+		middleware    func(http.HandlerFunc) http.HandlerFunc
+		expectedCode  int
+		expectedBody  string
+		expectHandler bool
+	}{
+		{
+			name:   "Role present",
+			claims: jwt.MapClaims{"roles": []interface{}{"user", "admin"}},
+			middleware: func(next http.HandlerFunc) http.HandlerFunc {
+				return RequireRole(next, RoleAdmin)
+			},
+			expectedCode:  http.StatusOK,
+			expectHandler: true,
+		},
+		{
+			name:   "Role missing",
+			claims: jwt.MapClaims{"roles": []interface{}{"user"}},
+			middleware: func(next http.HandlerFunc) http.HandlerFunc {
+				return RequireRole(next, RoleAdmin)
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "Forbidden: requires role admin",
+		},
+		{
+			name:   "Any of several permissions",
+			claims: jwt.MapClaims{"permissions": []interface{}{PermissionSecretRead}},
+			middleware: func(next http.HandlerFunc) http.HandlerFunc {
+				return RequirePermission(next, PermissionUsersManage, PermissionSecretRead)
+			},
+			expectedCode:  http.StatusOK,
+			expectHandler: true,
+		},
+		{
+			name:   "Permission missing",
+			claims: jwt.MapClaims{"username": "testuser"},
+			middleware: func(next http.HandlerFunc) http.HandlerFunc {
+				return RequirePermission(next, PermissionUsersManage)
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "Forbidden: requires permission users:manage",
+		},
+		{
+			name: "No claims in context",
+			middleware: func(next http.HandlerFunc) http.HandlerFunc {
+				return RequireRole(next, RoleUser)
+			},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			next := func(w http.ResponseWriter, r *http.Request) { called = true }
+
+			req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), claimsContextKey, tt.claims))
+			}
+
+			rr := executeHandler(tt.middleware(next), req)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.expectedBody)
+			assert.Equal(t, tt.expectHandler, called)
+		})
+	}
+}
+
+func TestRoles_LoginTokenCarriesRoles(t *testing.T) {
+	ctx := context.Background()
+	repository := &SQLRepository{bd: newTestDB(t)}
+	keys := NewHMACKeyManager([]byte("test-secret-key"))
+
+	require.NoError(t, repository.CreateUser(ctx, "alice", "hash"))
+	require.NoError(t, repository.CreateUser(ctx, "bob", "hash"))
+	require.NoError(t, repository.AssignRole(ctx, "bob", RoleAdmin))
+	assert.Error(t, repository.AssignRole(ctx, "bob", "superuser"))
+
+	handler := LoginHandler{Keys: keys, Roles: repository}
+	secret := middelwareHandler(RequirePermission(secretHandler, PermissionUsersManage), keys, nil)
+
+	tests := []struct {
+		username     string
+		expectedCode int
+	}{
+		{username: "alice", expectedCode: http.StatusForbidden},
+		{username: "bob", expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			token, _, err := handler.generateAccessToken(ctx, tt.username)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+			req.Header.Set("Authorization", token)
+
+			assert.Equal(t, tt.expectedCode, executeHandler(secret, req).Code)
+		})
+	}
+}
@@ -1,37 +1,342 @@
 package main
 
 import (
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	logFileMode      = 0640
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// Ротация и хранение старых файлов. Нулевые значения выключают
+// соответствующее правило
+type SaverOptions struct {
+	MaxSize    int64         // Байт в файле до ротации
+	Daily      bool          // Ротация при смене суток
+	Compress   bool          // Сжимать ротированные файлы gzip
+	MaxAge     time.Duration // Удалять ротированные файлы старше
+	MaxBackups int           // Хранить не больше стольких ротированных файлов
+}
+
+// Файл, в который пишет логгер. Пустой путь — запись никуда не идёт,
+// логи остаются только в stdout. Ротированный файл переименовывается
+// в app-<время>.log рядом с исходным
 type Saver struct {
-	file *os.File
+	path    string
+	options SaverOptions
+	now     func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	day     time.Time
+	stopped bool
+
+	// Сжатие и удаление старых файлов идут в фоне, по одному за раз
+	millMu sync.Mutex
+	mills  sync.WaitGroup
 }
 
-func NewSaver() Saver {
-	saver := new(Saver)
-	return *saver
+func NewSaver(path string, options SaverOptions) *Saver {
+	return &Saver{path: path, options: options, now: time.Now}
 }
 
 func (saver *Saver) Start() error {
-	logFile, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if saver.path == "" {
+		return nil
+	}
+
+	saver.mu.Lock()
+	defer saver.mu.Unlock()
+
+	saver.stopped = false
+	return saver.open()
+}
+
+func (saver *Saver) open() error {
+	logFile, err := os.OpenFile(saver.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFileMode)
+	if err != nil {
+		return err
+	}
+
+	info, err := logFile.Stat()
 	if err != nil {
+		logFile.Close()
 		return err
 	}
 
 	saver.file = logFile
+	saver.size = info.Size()
+
+	// Непустой файл относится к дню последней записи в него
+	saver.day = startOfDay(saver.now())
+	if info.Size() > 0 {
+		saver.day = startOfDay(info.ModTime())
+	}
+
+	return nil
+}
+
+func (saver *Saver) Write(p []byte) (int, error) {
+	saver.mu.Lock()
+	defer saver.mu.Unlock()
+
+	// После Stop записи отбрасываются
+	if saver.path == "" || saver.stopped {
+		return len(p), nil
+	}
+
+	// Файл мог остаться закрытым после неудачной ротации
+	if saver.file == nil {
+		if err := saver.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if saver.shouldRotate(len(p)) {
+		if err := saver.rotate(); err != nil {
+			if saver.file == nil {
+				return 0, err
+			}
+			// Запись идёт в прежний файл, ротация повторится позже
+			fmt.Fprintf(os.Stderr, "Log rotation error: %v\n", err)
+		}
+	}
+
+	n, err := saver.file.Write(p)
+	saver.size += int64(n)
+	return n, err
+}
 
-	multiWritter := io.MultiWriter(os.Stdout, saver.file)
-	log.SetOutput(multiWritter)
+func (saver *Saver) shouldRotate(next int) bool {
+	if saver.size == 0 {
+		return false
+	}
+
+	if saver.options.MaxSize > 0 && saver.size+int64(next) > saver.options.MaxSize {
+		return true
+	}
+
+	return saver.options.Daily && startOfDay(saver.now()).After(saver.day)
+}
+
+// При ошибке файл по исходному пути открывается заново, чтобы записи
+// не терялись. Если и это не удалось, file остаётся nil и Write
+// попробует открыть его снова
+func (saver *Saver) rotate() error {
+	backup := saver.freeBackupName(saver.now())
+
+	err := saver.file.Close()
+	saver.file = nil
+	if err == nil {
+		err = os.Rename(saver.path, backup)
+	}
+
+	if err != nil {
+		return errors.Join(err, saver.open())
+	}
+
+	if err := saver.open(); err != nil {
+		return err
+	}
+
+	saver.mills.Add(1)
+	go func() {
+		defer saver.mills.Done()
+		saver.mill(backup)
+	}()
 
 	return nil
 }
 
+// app.log -> app-2024-01-02T15-04-05.000.log
+func (saver *Saver) backupName(t time.Time) string {
+	ext := filepath.Ext(saver.path)
+	prefix := strings.TrimSuffix(saver.path, ext)
+	return prefix + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// Две ротации в одну миллисекунду не должны затирать друг друга
+func (saver *Saver) freeBackupName(t time.Time) string {
+	for {
+		name := saver.backupName(t)
+		if !fileExists(name) && !fileExists(name+".gz") {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Сжимает свежий ротированный файл и удаляет лишние старые
+func (saver *Saver) mill(backup string) {
+	saver.millMu.Lock()
+	defer saver.millMu.Unlock()
+
+	if saver.options.Compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "Log compression error for %s: %v\n", backup, err)
+		}
+	}
+
+	if err := saver.removeOldBackups(); err != nil {
+		fmt.Fprintf(os.Stderr, "Log retention error: %v\n", err)
+	}
+}
+
+type logBackup struct {
+	path      string
+	rotatedAt time.Time
+}
+
+func (saver *Saver) backups() ([]logBackup, error) {
+	dir := filepath.Dir(saver.path)
+	ext := filepath.Ext(saver.path)
+	prefix := strings.TrimSuffix(filepath.Base(saver.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []logBackup
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		rotatedAt, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, logBackup{path: filepath.Join(dir, entry.Name()), rotatedAt: rotatedAt})
+	}
+
+	// Новые первыми
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotatedAt.After(backups[j].rotatedAt)
+	})
+
+	return backups, nil
+}
+
+func (saver *Saver) removeOldBackups() error {
+	if saver.options.MaxBackups <= 0 && saver.options.MaxAge <= 0 {
+		return nil
+	}
+
+	backups, err := saver.backups()
+	if err != nil {
+		return err
+	}
+
+	cutoff := saver.now().Add(-saver.options.MaxAge)
+
+	var errs []error
+	for i, backup := range backups {
+		tooMany := saver.options.MaxBackups > 0 && i >= saver.options.MaxBackups
+		tooOld := saver.options.MaxAge > 0 && backup.rotatedAt.Before(cutoff)
+
+		if tooMany || tooOld {
+			if err := os.Remove(backup.path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, logFileMode)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(target)
+	if _, err := io.Copy(writer, source); err != nil {
+		target.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		target.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	if err := target.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Переоткрывает файл по тому же пути: нужно после того, как внешний
+// logrotate переименовал его (обычно по SIGHUP)
+func (saver *Saver) Reopen() error {
+	saver.mu.Lock()
+	defer saver.mu.Unlock()
+
+	if saver.path == "" || saver.stopped {
+		return nil
+	}
+
+	// file == nil после неудачной ротации: просто открываем заново
+	if saver.file != nil {
+		err := saver.file.Close()
+		saver.file = nil
+		if err != nil {
+			return errors.Join(err, saver.open())
+		}
+	}
+
+	return saver.open()
+}
+
 func (saver *Saver) Stop() error {
+	saver.mu.Lock()
+	defer saver.mu.Unlock()
+
+	saver.mills.Wait()
+	saver.stopped = true
+
+	if saver.file == nil {
+		return nil
+	}
+
 	err := saver.file.Close()
-	log.SetOutput(os.Stdout)
+	saver.file = nil
 
 	return err
 }
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
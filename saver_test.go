@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Saver с управляемыми часами в отдельном каталоге
+func newTestSaver(t *testing.T, options SaverOptions) (*Saver, *time.Time) {
+	clock := time.Date(2024, 3, 10, 23, 0, 0, 0, time.Local)
+	saver := NewSaver(filepath.Join(t.TempDir(), "app.log"), options)
+	saver.now = func() time.Time { return clock }
+
+	require.NoError(t, saver.Start())
+	t.Cleanup(func() { saver.Stop() })
+
+	return saver, &clock
+}
+
+func writeString(t *testing.T, saver *Saver, value string) {
+	_, err := saver.Write([]byte(value))
+	require.NoError(t, err)
+}
+
+func readLogFile(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		reader = gz
+	}
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestSaver_WritesToFile(t *testing.T) {
+	saver, _ := newTestSaver(t, SaverOptions{})
+
+	writeString(t, saver, "first\n")
+	require.NoError(t, saver.Stop())
+
+	// После остановки записи молча отбрасываются
+	writeString(t, saver, "dropped\n")
+
+	assert.Equal(t, "first\n", readLogFile(t, saver.path))
+
+	info, err := os.Stat(saver.path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(logFileMode), info.Mode().Perm())
+}
+
+func TestSaver_EmptyPath(t *testing.T) {
+	saver := NewSaver("", SaverOptions{})
+	require.NoError(t, saver.Start())
+
+	n, err := saver.Write([]byte("ignored"))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.NoError(t, saver.Stop())
+}
+
+func TestSaver_RotatesBySize(t *testing.T) {
+	saver, clock := newTestSaver(t, SaverOptions{MaxSize: 10})
+
+	writeString(t, saver, "12345678\n")
+	*clock = clock.Add(time.Second)
+	writeString(t, saver, "abc\n")
+	require.NoError(t, saver.Stop())
+
+	backups, err := saver.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	assert.Equal(t, saver.backupName(*clock), backups[0].path)
+	assert.Equal(t, "12345678\n", readLogFile(t, backups[0].path))
+	assert.Equal(t, "abc\n", readLogFile(t, saver.path))
+}
+
+func TestSaver_RotatesDaily(t *testing.T) {
+	saver, clock := newTestSaver(t, SaverOptions{Daily: true})
+
+	writeString(t, saver, "monday\n")
+	*clock = clock.Add(30 * time.Minute)
+	writeString(t, saver, "still monday\n")
+
+	backups, err := saver.backups()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+
+	*clock = clock.Add(time.Hour) // 00:30 следующего дня
+	writeString(t, saver, "tuesday\n")
+	require.NoError(t, saver.Stop())
+
+	backups, err = saver.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, "monday\nstill monday\n", readLogFile(t, backups[0].path))
+	assert.Equal(t, "tuesday\n", readLogFile(t, saver.path))
+}
+
+func TestSaver_CompressesAndKeepsMaxBackups(t *testing.T) {
+	saver, clock := newTestSaver(t, SaverOptions{MaxSize: 5, Compress: true, MaxBackups: 2})
+
+	for i := 0; i < 5; i++ {
+		*clock = clock.Add(time.Second)
+		writeString(t, saver, fmt.Sprintf("log-%d\n", i))
+	}
+	require.NoError(t, saver.Stop())
+
+	backups, err := saver.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	for i, backup := range backups {
+		assert.True(t, strings.HasSuffix(backup.path, ".log.gz"), backup.path)
+		assert.Equal(t, fmt.Sprintf("log-%d\n", 3-i), readLogFile(t, backup.path))
+	}
+	assert.Equal(t, "log-4\n", readLogFile(t, saver.path))
+}
+
+func TestSaver_RemovesOldBackups(t *testing.T) {
+	saver, clock := newTestSaver(t, SaverOptions{MaxSize: 5, MaxAge: 24 * time.Hour})
+
+	old := saver.backupName(clock.Add(-48 * time.Hour))
+	recent := saver.backupName(clock.Add(-time.Hour))
+	unrelated := filepath.Join(filepath.Dir(saver.path), "app-notes.log")
+	for _, path := range []string{old, recent, unrelated} {
+		require.NoError(t, os.WriteFile(path, []byte("x"), 0600))
+	}
+
+	writeString(t, saver, "first\n")
+	writeString(t, saver, "second\n")
+	require.NoError(t, saver.Stop())
+
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, unrelated)
+}
+
+func TestSaver_Reopen(t *testing.T) {
+	saver, _ := newTestSaver(t, SaverOptions{})
+
+	writeString(t, saver, "before\n")
+
+	// Так поступает внешний logrotate
+	moved := saver.path + ".1"
+	require.NoError(t, os.Rename(saver.path, moved))
+	writeString(t, saver, "still old file\n")
+
+	require.NoError(t, saver.Reopen())
+	writeString(t, saver, "after\n")
+	require.NoError(t, saver.Stop())
+
+	assert.Equal(t, "before\nstill old file\n", readLogFile(t, moved))
+	assert.Equal(t, "after\n", readLogFile(t, saver.path))
+}
+
+func TestSaver_RotationFailureKeepsWriting(t *testing.T) {
+	saver, clock := newTestSaver(t, SaverOptions{MaxSize: 10})
+
+	writeString(t, saver, "12345678\n")
+
+	// Файл удалили снаружи: переименовать при ротации нечего
+	require.NoError(t, os.Remove(saver.path))
+	*clock = clock.Add(time.Second)
+	writeString(t, saver, "abc\n")
+	writeString(t, saver, "def\n")
+	require.NoError(t, saver.Stop())
+
+	assert.Equal(t, "abc\ndef\n", readLogFile(t, saver.path))
+}
+
+func TestSaver_RecoversAfterFailedReopen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	require.NoError(t, os.Mkdir(dir, 0750))
+
+	saver := NewSaver(filepath.Join(dir, "app.log"), SaverOptions{MaxSize: 10})
+	require.NoError(t, saver.Start())
+	t.Cleanup(func() { saver.Stop() })
+
+	writeString(t, saver, "12345678\n")
+
+	// Каталог пропал: ни переименовать, ни открыть файл заново нельзя
+	require.NoError(t, os.RemoveAll(dir))
+	_, err := saver.Write([]byte("lost\n"))
+	assert.Error(t, err)
+	_, err = saver.Write([]byte("lost\n"))
+	assert.Error(t, err)
+	assert.Error(t, saver.Reopen())
+
+	require.NoError(t, os.Mkdir(dir, 0750))
+	require.NoError(t, saver.Reopen())
+	writeString(t, saver, "back\n")
+	require.NoError(t, saver.Stop())
+
+	assert.Equal(t, "back\n", readLogFile(t, saver.path))
+}
+
+func TestSaver_ConcurrentWritesDuringRotation(t *testing.T) {
+	saver := NewSaver(filepath.Join(t.TempDir(), "app.log"), SaverOptions{MaxSize: 200})
+	require.NoError(t, saver.Start())
+
+	var wg sync.WaitGroup
+	for writer := 0; writer < 8; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				saver.Write([]byte(fmt.Sprintf("writer-%d line-%d\n", writer, i)))
+			}
+		}(writer)
+	}
+	wg.Wait()
+	require.NoError(t, saver.Stop())
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(saver.path), "app*.log"))
+	require.NoError(t, err)
+	assert.Greater(t, len(files), 1)
+
+	// Ни одна строка не потеряна и не разорвана ротацией
+	lines := 0
+	for _, path := range files {
+		scanner := bufio.NewScanner(strings.NewReader(readLogFile(t, path)))
+		for scanner.Scan() {
+			assert.Regexp(t, `^writer-\d line-\d+$`, scanner.Text())
+			lines++
+		}
+	}
+	assert.Equal(t, 8*50, lines)
+}
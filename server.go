@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Сколько после остановки сервера ждать обработчики, оставшиеся
+// от закрытых по таймауту соединений
+const handlerExitTimeout = 5 * time.Second
+
+// Сервер с таймаутами: без них медленный клиент может держать
+// соединение сколько угодно (slowloris)
+func newHTTPServer(config *Config, handler http.Handler, logger *slog.Logger) *http.Server {
+	return &http.Server{
+		Addr:              config.Addr(),
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.With("component", "http").Handler(), slog.LevelWarn),
+	}
+}
+
+// Обслуживает запросы, пока не отменён ctx, затем перестаёт принимать
+// новые соединения и ждёт текущие запросы не дольше drainTimeout.
+// Незавершённые к этому сроку соединения закрываются
+func serveHTTP(ctx context.Context, server *http.Server, listener net.Listener, drainTimeout time.Duration, logger *slog.Logger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", "drain_timeout", drainTimeout.String())
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("drain in-flight requests: %w", err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Считает работающие обработчики. server.Close закрывает соединения,
+// но не ждёт обработчиков, а компоненты, которыми они пользуются,
+// закрываются сразу после остановки сервера
+type InFlightRequests struct {
+	wg sync.WaitGroup
+
+	// После Wait новые обработчики не запускаются: wg.Add во время
+	// wg.Wait с нулевым счётчиком недопустим
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (f *InFlightRequests) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.RLock()
+		if f.closed {
+			f.mu.RUnlock()
+			writeError(w, r, http.StatusServiceUnavailable, ErrCodeShuttingDown, "Server is shutting down")
+			return
+		}
+		f.wg.Add(1)
+		f.mu.RUnlock()
+
+		defer f.wg.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Перестаёт пускать новые запросы и ждёт завершения текущих не дольше
+// timeout. false — кто-то ещё работает
+func (f *InFlightRequests) Wait(timeout time.Duration) bool {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPServer_Timeouts(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadHeaderTimeout = 3 * time.Second
+
+	server := newHTTPServer(&config, http.NotFoundHandler(), discardLogger)
+
+	assert.Equal(t, ":8888", server.Addr)
+	assert.Equal(t, 3*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, config.ReadTimeout, server.ReadTimeout)
+	assert.Equal(t, config.WriteTimeout, server.WriteTimeout)
+	assert.Equal(t, config.IdleTimeout, server.IdleTimeout)
+	assert.NotNil(t, server.ErrorLog)
+}
+
+// Запускает serveHTTP с обработчиком, который ждёт release, и шлёт
+// запрос. Возвращает канал с ошибкой serveHTTP и канал с ответом
+func startDrainTest(t *testing.T, release chan struct{}, drainTimeout time.Duration) (context.CancelFunc, chan error, chan *http.Response) {
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveHTTP(ctx, server, listener, drainTimeout, discardLogger)
+	}()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- nil
+			return
+		}
+		responses <- resp
+	}()
+
+	<-started
+	return cancel, serveErr, responses
+}
+
+func TestServeHTTP_DrainsInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	cancel, serveErr, responses := startDrainTest(t, release, 5*time.Second)
+
+	cancel()
+
+	// Пока запрос не завершён, сервер не останавливается
+	select {
+	case err := <-serveErr:
+		t.Fatalf("serveHTTP returned before the request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	resp := <-responses
+	require.NotNil(t, resp)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "done", string(body))
+
+	require.NoError(t, <-serveErr)
+}
+
+func TestServeHTTP_DrainDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	cancel, serveErr, responses := startDrainTest(t, release, 50*time.Millisecond)
+	cancel()
+
+	err := <-serveErr
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Не успевший завершиться запрос обрывается
+	assert.Nil(t, <-responses)
+}
+
+func TestServeHTTP_ListenerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener.Close()
+
+	err = serveHTTP(context.Background(), &http.Server{}, listener, time.Second, discardLogger)
+	assert.Error(t, err)
+}
+
+func TestInFlightRequests_Wait(t *testing.T) {
+	var inFlight InFlightRequests
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	handler := inFlight.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	assert.False(t, inFlight.Wait(50*time.Millisecond))
+
+	close(release)
+	assert.True(t, inFlight.Wait(time.Second))
+}
+
+func TestInFlightRequests_RejectsAfterWait(t *testing.T) {
+	var inFlight InFlightRequests
+	called := false
+	handler := inFlight.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	require.True(t, inFlight.Wait(time.Second))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.False(t, called)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	accessTokenCookie  = "access_token"
+	refreshTokenCookie = "refresh_token"
+	// Refresh токен нужен только обмену, в остальные запросы браузер
+	// его не отправляет
+	refreshTokenPath = "/token/refresh"
+	csrfCookie       = "csrf_token"
+	csrfHeader       = "X-CSRF-Token"
+
+	// Клиент просит сессию в cookie вместо токена в теле ответа
+	sessionModeHeader = "X-Session-Mode"
+	sessionModeCookie = "cookie"
+)
+
+type CookieSessionResponse struct {
+	TokenType string `json:"token_type"`
+	ExpiresIn int    `json:"expires_in"`
+	Scope     string `json:"scope,omitempty"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+func (l *LoginHandler) wantsCookieSession(r *http.Request) bool {
+	return l.CookieSessions && r.Header.Get(sessionModeHeader) == sessionModeCookie
+}
+
+// Токены уходят в HttpOnly cookie и недоступны из JavaScript. CSRF токен,
+// наоборот, читается скриптом и возвращается в заголовке X-CSRF-Token
+func (l *LoginHandler) writeSessionCookies(w http.ResponseWriter, r *http.Request, accessToken, refreshToken string, permissions []string) {
+	csrfToken, err := generateOpaqueToken()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Token generating error")
+		return
+	}
+
+	ttl := l.accessTTL()
+	sessionTTL := ttl
+	if refreshToken != "" {
+		sessionTTL = l.refreshTTL()
+		http.SetCookie(w, sessionCookie(refreshTokenCookie, refreshToken, sessionTTL, true))
+	}
+
+	http.SetCookie(w, sessionCookie(accessTokenCookie, accessToken, ttl, true))
+	http.SetCookie(w, sessionCookie(csrfCookie, csrfToken, sessionTTL, false))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(CookieSessionResponse{
+		TokenType: sessionModeCookie,
+		ExpiresIn: int(ttl / time.Second),
+		Scope:     strings.Join(permissions, " "),
+		CSRFToken: csrfToken,
+	})
+}
+
+func sessionCookie(name, value string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     sessionCookiePath(name),
+		MaxAge:   int(ttl / time.Second),
+		Secure:   true,
+		HttpOnly: httpOnly,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+func sessionCookiePath(name string) string {
+	if name == refreshTokenCookie {
+		return refreshTokenPath
+	}
+	return "/"
+}
+
+// Браузер удаляет cookie, только если совпадает и путь
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{accessTokenCookie, refreshTokenCookie, csrfCookie} {
+		cookie := sessionCookie(name, "", 0, name != csrfCookie)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// Заголовок Authorization имеет приоритет над cookie
+func accessTokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, err := parseBearerHeader(header)
+		return token, false, err
+	}
+
+	if cookie, err := r.Cookie(accessTokenCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, true, nil
+	}
+
+	return "", false, nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// Double-submit: значение из cookie должно совпасть с заголовком. Чужой сайт
+// может заставить браузер отправить cookie, но прочитать её значение не может
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	header := r.Header.Get(csrfHeader)
+	return header != "" && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func cookiesByName(rr *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rr.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func cookieSessionLogin(t *testing.T, cookieSessions bool) *httptest.ResponseRecorder {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+
+	mockRepository := &MockUserRepository{}
+	mockRepository.On("GetUserByUsername", "validuser").Return(string(hashedPassword), nil)
+	mockRepository.On("GetUserProfile", "validuser").Return(UserProfile{ID: 1, Username: "validuser"}, nil)
+
+	handler := LoginHandler{
+		Repo:           mockRepository,
+		Hasher:         &BcryptHasher{},
+		Keys:           NewHMACKeyManager([]byte("test-secret-key")),
+		CookieSessions: cookieSessions,
+	}
+
+	req := createTestRequest(http.MethodPost, "/login", map[string]interface{}{
+		"username": "validuser",
+		"password": "password123",
+	})
+	req.Header.Set(sessionModeHeader, sessionModeCookie)
+
+	rr := executeHandler(handler.loginHandler, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr
+}
+
+func TestLoginHandler_CookieSession(t *testing.T) {
+	rr := cookieSessionLogin(t, true)
+
+	cookies := cookiesByName(rr)
+	require.Contains(t, cookies, accessTokenCookie)
+	require.Contains(t, cookies, csrfCookie)
+
+	access := cookies[accessTokenCookie]
+	assert.True(t, access.HttpOnly)
+	assert.True(t, access.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, access.SameSite)
+	assert.False(t, cookies[csrfCookie].HttpOnly)
+
+	var response CookieSessionResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, cookies[csrfCookie].Value, response.CSRFToken)
+	assert.NotContains(t, rr.Body.String(), access.Value)
+}
+
+func TestLoginHandler_CookieSessionDisabled(t *testing.T) {
+	rr := cookieSessionLogin(t, false)
+
+	assert.Empty(t, rr.Result().Cookies())
+
+	var response TokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.NotEmpty(t, response.AccessToken)
+}
+
+func TestMiddelware_CookieAuthAndCSRF(t *testing.T) {
+	cookies := cookiesByName(cookieSessionLogin(t, true))
+	middleware := middelwareHandler(secretHandler, NewHMACKeyManager([]byte("test-secret-key")), nil)
+
+	tests := []struct {
+		name         string
+		method       string
+		csrf         string
+		expectedCode int
+	}{
+		{name: "GET needs no CSRF token", method: http.MethodGet, expectedCode: http.StatusOK},
+		{name: "POST without CSRF token", method: http.MethodPost, expectedCode: http.StatusForbidden},
+		{name: "POST with wrong CSRF token", method: http.MethodPost, csrf: "forged", expectedCode: http.StatusForbidden},
+		{name: "POST with CSRF token", method: http.MethodPost, csrf: cookies[csrfCookie].Value, expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/secret", nil)
+			req.AddCookie(cookies[accessTokenCookie])
+			req.AddCookie(cookies[csrfCookie])
+			if tt.csrf != "" {
+				req.Header.Set(csrfHeader, tt.csrf)
+			}
+
+			rr := executeHandler(middleware, req)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+
+			if tt.expectedCode == http.StatusForbidden {
+				assert.Equal(t, ErrCodeCSRFFailed, decodeErrorResponse(t, rr).Code)
+			}
+		})
+	}
+}
+
+func TestClearSessionCookies_MatchPaths(t *testing.T) {
+	rr := httptest.NewRecorder()
+	clearSessionCookies(rr)
+
+	cookies := cookiesByName(rr)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, refreshTokenPath, cookies[refreshTokenCookie].Path)
+	assert.Equal(t, "/", cookies[accessTokenCookie].Path)
+	assert.Equal(t, "/", cookies[csrfCookie].Path)
+
+	for _, cookie := range cookies {
+		assert.Equal(t, -1, cookie.MaxAge)
+	}
+}
+
+func TestSessionCookie_RefreshScopedToRefreshPath(t *testing.T) {
+	cookie := sessionCookie(refreshTokenCookie, "token", time.Hour, true)
+	assert.Equal(t, refreshTokenPath, cookie.Path)
+	assert.True(t, cookie.HttpOnly)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Выполняет fn в транзакции BEGIN IMMEDIATE на выделенном соединении.
+// Блокировка записи берётся сразу, поэтому прочитанное внутри fn никто
+// не изменит до COMMIT, в том числе другой процесс с той же базой.
+// BeginTx начинает отложенную транзакцию, а её блокировку чтения под
+// конкурентной записью не повысить
+func immediateTx(ctx context.Context, conn *sql.Conn, fn func() error) error {
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+
+	return nil
+}
+
+// База занята другим писателем дольше busy_timeout
+func isSQLiteBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod       = 30 * time.Second
+	totpDigits       = 6
+	totpSkew         = 1 // Допустимое расхождение часов в шагах
+	totpSecretBytes  = 20
+	recoveryCodes    = 10
+	recoveryCodeSize = 10 // Байт: 80 бит, перебор по дампу базы нереален
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+func totpURI(issuer, username, secret string) string {
+	label := url.PathEscape(issuer + ":" + username)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// Код по RFC 4226 для заданного шага времени (RFC 6238)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// Возвращает шаг, на котором код совпал. Шаг нужен вызывающему,
+// чтобы запретить повторное использование того же кода
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodes)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		// 16 символов base32 группами по 4: abcd-efgh-ijkl-mnop
+		encoded := strings.ToLower(totpEncoding.EncodeToString(buf))
+		groups := make([]string, 0, len(encoded)/4)
+		for j := 0; j < len(encoded); j += 4 {
+			groups = append(groups, encoded[j:j+4])
+		}
+		codes[i] = strings.Join(groups, "-")
+	}
+
+	return codes, nil
+}
+
+// Регистр, пробелы и дефисы при вводе кода не важны
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// Шифрует TOTP секреты перед записью в базу (AES-256-GCM). Имя пользователя
+// идёт в associated data, так что секрет нельзя перенести в чужую запись
+type SecretCipher struct {
+	aead cipher.AEAD
+	// Ключ HMAC для кодов восстановления, выводится из того же ключа
+	macKey []byte
+}
+
+func NewSecretCipher(encodedKey string) (*SecretCipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("mfa_encryption_key must be base64: %w", err)
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("mfa_encryption_key must decode to 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte("recovery-codes"))
+
+	return &SecretCipher{aead: aead, macKey: derive.Sum(nil)}, nil
+}
+
+// HMAC с ключом сервера и именем пользователя: без ключа хэши из дампа
+// базы не перебрать, а одинаковые коды разных пользователей не совпадут
+func (c *SecretCipher) HashRecoveryCode(code, owner string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(owner))
+	mac.Write([]byte{0})
+	mac.Write([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *SecretCipher) Encrypt(plaintext, owner string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), []byte(owner))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *SecretCipher) Decrypt(encoded, owner string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(owner))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
@@ -3,11 +3,21 @@ package main
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type IRepository interface {
 	GetUserByUsername(context.Context, string) (string, error)
+	GetUserProfile(context.Context, string) (UserProfile, error)
 	CreateUser(context.Context, string, string) error
+	UpdatePassword(context.Context, string, string) error
+}
+
+// Данные пользователя без секретов: хэша пароля и TOTP
+type UserProfile struct {
+	ID         int64
+	Username   string
+	MFAEnabled bool
 }
 
 type SQLRepository struct {
@@ -21,7 +31,136 @@ func (r *SQLRepository) GetUserByUsername(ctx context.Context, name string) (str
 	return password, err
 }
 
+func (r *SQLRepository) GetUserProfile(ctx context.Context, name string) (UserProfile, error) {
+	var profile UserProfile
+	row := r.bd.QueryRowContext(ctx, "SELECT id, username, totp_enabled FROM users WHERE username = ?", name)
+	err := row.Scan(&profile.ID, &profile.Username, &profile.MFAEnabled)
+	return profile, err
+}
+
+// Новый пользователь сразу получает базовую роль в той же транзакции
 func (r *SQLRepository) CreateUser(ctx context.Context, name, hashedPassword string) error {
-	_, err := r.bd.ExecContext(ctx, "INSERT INTO users (username, password) VALUES (?, ?)", name, hashedPassword)
+	tx, err := r.bd.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (username, password) VALUES (?, ?)", name, hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT users.id, roles.id FROM users, roles
+		WHERE users.username = ? AND roles.name = ?`, name, RoleUser)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLRepository) UpdatePassword(ctx context.Context, name, hashedPassword string) error {
+	_, err := r.bd.ExecContext(ctx, "UPDATE users SET password = ? WHERE username = ?", hashedPassword, name)
+	return err
+}
+
+func (r *SQLRepository) GetUserRoles(ctx context.Context, name string) ([]string, error) {
+	return r.queryStrings(ctx, `
+		SELECT roles.name FROM roles
+		JOIN user_roles ON user_roles.role_id = roles.id
+		JOIN users ON users.id = user_roles.user_id
+		WHERE users.username = ?
+		ORDER BY roles.name`, name)
+}
+
+func (r *SQLRepository) GetUserPermissions(ctx context.Context, name string) ([]string, error) {
+	return r.queryStrings(ctx, `
+		SELECT DISTINCT role_permissions.permission FROM role_permissions
+		JOIN user_roles ON user_roles.role_id = role_permissions.role_id
+		JOIN users ON users.id = user_roles.user_id
+		WHERE users.username = ?
+		ORDER BY role_permissions.permission`, name)
+}
+
+// Возвращает sql.ErrNoRows, если пользователя или роли не существует
+func (r *SQLRepository) AssignRole(ctx context.Context, name, role string) error {
+	var exists int
+	row := r.bd.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM users, roles WHERE users.username = ? AND roles.name = ?`, name, role)
+	if err := row.Scan(&exists); err != nil {
+		return err
+	}
+
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err := r.bd.ExecContext(ctx, `
+		INSERT OR IGNORE INTO user_roles (user_id, role_id)
+		SELECT users.id, roles.id FROM users, roles
+		WHERE users.username = ? AND roles.name = ?`, name, role)
+	return err
+}
+
+func (r *SQLRepository) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := r.bd.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (r *SQLRepository) GetLockState(ctx context.Context, name string) (LockState, error) {
+	var state LockState
+	var lockedUntil int64
+
+	row := r.bd.QueryRowContext(ctx, "SELECT failed_attempts, locked_until FROM users WHERE username = ?", name)
+	err := row.Scan(&state.FailedAttempts, &lockedUntil)
+	state.LockedUntil = time.Unix(lockedUntil, 0)
+
+	return state, err
+}
+
+// Счётчик увеличивается атомарно, чтобы параллельные попытки не терялись
+func (r *SQLRepository) IncrementFailedAttempts(ctx context.Context, name string) (int, error) {
+	var attempts int
+	row := r.bd.QueryRowContext(ctx,
+		"UPDATE users SET failed_attempts = failed_attempts + 1 WHERE username = ? RETURNING failed_attempts", name)
+	err := row.Scan(&attempts)
+	return attempts, err
+}
+
+func (r *SQLRepository) LockUntil(ctx context.Context, name string, until time.Time) error {
+	_, err := r.bd.ExecContext(ctx, "UPDATE users SET locked_until = ? WHERE username = ?", until.Unix(), name)
+	return err
+}
+
+// Возвращает sql.ErrNoRows, если пользователя не существует
+func (r *SQLRepository) ResetFailedAttempts(ctx context.Context, name string) error {
+	result, err := r.bd.ExecContext(ctx,
+		"UPDATE users SET failed_attempts = 0, locked_until = 0 WHERE username = ?", name)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return err
 }